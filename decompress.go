@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Magic numbers at the start of compressed files
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressingReader looks at the first few bytes from fin for the
+// magic number of a compressed format that logrotate might use. It
+// returns a reader of decompressed bytes if it finds one, or a reader
+// of fin's bytes as-is if not, along with a function that cleans up
+// after decompression. File names don't matter, so compressed stdin
+// works too.
+func decompressingReader(fin io.Reader) (io.Reader, func() error, error) {
+	br := bufio.NewReader(fin)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+	noCleanup := func() error { return nil }
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gzip input: %v", err)
+		}
+		return zr, zr.Close, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(br), noCleanup, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstdReader(br)
+	}
+	return br, noCleanup, nil
+}
+
+// zstdReader decompresses with an external zstd program,
+// the Go standard library not having a zstd decompressor.
+func zstdReader(compressed io.Reader) (io.Reader, func() error, error) {
+	cmd := exec.Command("zstd", "-d", "-c", "-q")
+	cmd.Stdin = compressed
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, nil, errors.New("zstd compressed input needs a zstd executable on PATH")
+		}
+		return nil, nil, err
+	}
+	zr := &eofReader{r: out}
+	wait := func() error {
+		if !zr.eof {
+			// Reading stopped early, maybe on a too-long line. zstd
+			// could be stuck writing to a full pipe, and would never
+			// exit, so stop it, and don't count how it died as an error.
+			out.Close()
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil
+		}
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("zstd: %v", err)
+		}
+		return nil
+	}
+	return zr, wait, nil
+}
+
+// eofReader notes whether reading got to the end of r
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (er *eofReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err == io.EOF {
+		er.eof = true
+	}
+	return n, err
+}
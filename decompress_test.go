@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bzip2Lines is "line one\nline two\n", bzip2 compressed
+var bzip2Lines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x8c, 0x77,
+	0xbf, 0xde, 0x00, 0x00, 0x04, 0xd1, 0x80, 0x00, 0x10, 0x40, 0x00, 0x02,
+	0x25, 0x84, 0x80, 0x20, 0x00, 0x31, 0x06, 0x4c, 0x40, 0xc8, 0x69, 0xa6,
+	0x8f, 0x0b, 0x2c, 0x20, 0x98, 0x9c, 0x27, 0x8b, 0xb9, 0x22, 0x9c, 0x28,
+	0x48, 0x46, 0x3b, 0xdf, 0xef, 0x00,
+}
+
+func gzipped(t *testing.T, text string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressingReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "plain text",
+			input: []byte("line one\nline two\n"),
+			want:  "line one\nline two\n",
+		},
+		{
+			name:  "gzip",
+			input: gzipped(t, "line one\nline two\n"),
+			want:  "line one\nline two\n",
+		},
+		{
+			name:  "bzip2",
+			input: bzip2Lines,
+			want:  "line one\nline two\n",
+		},
+		{
+			name:  "shorter than any magic number",
+			input: []byte("ab\n"),
+			want:  "ab\n",
+		},
+		{
+			name:  "empty",
+			input: []byte{},
+			want:  "",
+		},
+		{
+			name:    "gzip magic, bad header",
+			input:   []byte{0x1f, 0x8b, 0x00, 0x00, 0x00},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, cleanup, err := decompressingReader(bytes.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decompressingReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("reading decompressed input: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decompressingReader() read %q, want %q", got, tt.want)
+			}
+			if err := cleanup(); err != nil {
+				t.Errorf("cleanup error = %v", err)
+			}
+		})
+	}
+}
+
+// stubZstd puts a shell script named zstd first on PATH. The script
+// "decompresses" by dropping the 4 bytes of the zstd magic number.
+func stubZstd(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub zstd is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "zstd"), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func zstdFramed(text string) []byte {
+	return append(append([]byte{}, zstdMagic...), text...)
+}
+
+func TestZstdReader(t *testing.T) {
+	stubZstd(t, "tail -c +5")
+	r, cleanup, err := decompressingReader(bytes.NewReader(zstdFramed("line one\nline two\n")))
+	if err != nil {
+		t.Fatalf("decompressingReader() error = %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decompressed input: %v", err)
+	}
+	if string(got) != "line one\nline two\n" {
+		t.Errorf("decompressingReader() read %q", got)
+	}
+	if err := cleanup(); err != nil {
+		t.Errorf("cleanup error = %v", err)
+	}
+}
+
+func TestZstdReader_Fails(t *testing.T) {
+	stubZstd(t, "cat >/dev/null; echo corrupt >&2; exit 1")
+	r, cleanup, err := decompressingReader(bytes.NewReader(zstdFramed("junk")))
+	if err != nil {
+		t.Fatalf("decompressingReader() error = %v", err)
+	}
+	io.ReadAll(r)
+	if err := cleanup(); err == nil {
+		t.Errorf("cleanup after zstd failed did not fail")
+	}
+}
+
+// TestZstdReader_StopEarly checks that cleanup doesn't wait forever
+// on a zstd with more to write, after reading stops part way through.
+func TestZstdReader_StopEarly(t *testing.T) {
+	stubZstd(t, "tail -c +5")
+	input := zstdFramed(strings.Repeat("x", 70*1024) + "\n" + strings.Repeat("line\n", 200000))
+	r, cleanup, err := decompressingReader(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("decompressingReader() error = %v", err)
+	}
+	if _, err := r.Read(make([]byte, 1024)); err != nil {
+		t.Fatalf("reading decompressed input: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- cleanup() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cleanup error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cleanup never returned")
+	}
+}
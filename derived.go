@@ -0,0 +1,175 @@
+package main
+
+import (
+	"combined/parser"
+	"combined/useragent"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// derivations compute fields that don't appear in log lines as such,
+// but come from picking apart fields that do. They only run when a
+// match expression or output asks for their field.
+var derivations = map[string]func(pe *parsedEntry) string{
+	"path": func(pe *parsedEntry) string {
+		return pe.requestURL().Path
+	},
+	"query": func(pe *parsedEntry) string {
+		q, err := url.QueryUnescape(pe.requestURL().RawQuery)
+		if err != nil {
+			return pe.requestURL().RawQuery
+		}
+		return q
+	},
+	"ext": func(pe *parsedEntry) string {
+		return strings.TrimPrefix(path.Ext(pe.requestURL().Path), ".")
+	},
+	"refhost": func(pe *parsedEntry) string {
+		return strings.ToLower(pe.referrerURL().Hostname())
+	},
+	"refpath": func(pe *parsedEntry) string {
+		return pe.referrerURL().Path
+	},
+	"refscheme": func(pe *parsedEntry) string {
+		return strings.ToLower(pe.referrerURL().Scheme)
+	},
+	"refexternal": func(pe *parsedEntry) string {
+		host := strings.ToLower(pe.referrerURL().Hostname())
+		if host == "" || siteHosts[host] {
+			return "false"
+		}
+		return "true"
+	},
+	"browser": func(pe *parsedEntry) string {
+		return pe.userAgentClass().Browser
+	},
+	"os": func(pe *parsedEntry) string {
+		return pe.userAgentClass().OS
+	},
+	"device": func(pe *parsedEntry) string {
+		return pe.userAgentClass().Device
+	},
+	"isbot": func(pe *parsedEntry) string {
+		return strconv.FormatBool(pe.userAgentClass().Bot)
+	},
+	"class": func(pe *parsedEntry) string {
+		if len(pe.fields) <= 6 {
+			return ""
+		}
+		code := pe.fields[6]
+		if len(code) != 3 || code[0] < '1' || code[0] > '5' {
+			return ""
+		}
+		return code[:1] + "xx"
+	},
+}
+
+// siteHosts are the -site flag's host names, which the refexternal
+// field doesn't count as external. With no -site flag, any
+// referrer with a host name is external.
+var siteHosts = map[string]bool{}
+
+// addSiteHosts puts the comma separated host names of a -site
+// flag in siteHosts. Host names don't care about case, so
+// siteHosts has them in lower case, as refhost does.
+func addSiteHosts(list string) {
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			siteHosts[strings.ToLower(host)] = true
+		}
+	}
+}
+
+// familyDerivations compute the parameterized fields
+// in parser.FieldFamilies, like param[utm_source]
+var familyDerivations = map[string]func(pe *parsedEntry, arg string) string{
+	"param": func(pe *parsedEntry, name string) string {
+		return pe.queryParams().Get(name)
+	},
+}
+
+var (
+	derivers     []func(pe *parsedEntry) string
+	deriversOnce sync.Once
+)
+
+// deriverFor finds the function that computes field n, or nil if field
+// n comes straight from log lines. It looks at the field registry
+// only once, after command line parsing has registered everything.
+func deriverFor(n int) func(pe *parsedEntry) string {
+	deriversOnce.Do(func() {
+		derivers = make([]func(pe *parsedEntry) string, len(parser.FieldNames))
+		for i, name := range parser.FieldNames {
+			if derive, ok := derivations[name]; ok {
+				derivers[i] = derive
+				continue
+			}
+			if family, arg, ok := parser.SplitFamily(name); ok {
+				if derive, ok := familyDerivations[family]; ok {
+					derivers[i] = func(pe *parsedEntry) string {
+						return derive(pe, arg)
+					}
+				}
+			}
+		}
+	})
+	if n < len(derivers) {
+		return derivers[n]
+	}
+	return nil
+}
+
+// requestURL parses the url field the first time a derived field
+// needs it. Malformed URLs come back as an empty *url.URL, so that
+// fields derived from them are empty.
+func (pe *parsedEntry) requestURL() *url.URL {
+	if pe.parsedURL == nil {
+		u, err := url.ParseRequestURI(pe.fields[4])
+		if err != nil {
+			u = &url.URL{}
+		}
+		pe.parsedURL = u
+	}
+	return pe.parsedURL
+}
+
+// referrerURL parses the referrer field the first time a derived
+// field needs it. A "-" referrer, or a malformed one, or one without
+// a scheme and host, comes back as an empty *url.URL, so that fields
+// derived from it are empty.
+func (pe *parsedEntry) referrerURL() *url.URL {
+	if pe.parsedReferrer == nil {
+		u, err := url.Parse(pe.fields[8])
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			u = &url.URL{}
+		}
+		pe.parsedReferrer = u
+	}
+	return pe.parsedReferrer
+}
+
+// userAgentRules classify user agent strings for the browser, os,
+// device and isbot fields. A -ua-rules file adds to them.
+var userAgentRules = useragent.Default()
+
+// userAgentClass classifies the useragent field the
+// first time a derived field needs it.
+func (pe *parsedEntry) userAgentClass() *useragent.Class {
+	if pe.uaClass == nil {
+		c := userAgentRules.Classify(pe.fields[9])
+		pe.uaClass = &c
+	}
+	return pe.uaClass
+}
+
+// queryParams parses the url field's query string
+// the first time a param[name] field needs it.
+func (pe *parsedEntry) queryParams() url.Values {
+	if pe.params == nil {
+		pe.params = pe.requestURL().Query()
+	}
+	return pe.params
+}
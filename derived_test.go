@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// entryWith makes a *parsedEntry from a log line with the given
+// url and referrer fields, the way combinedLogLineParser would.
+func entryWith(t *testing.T, url, referrer string) *parsedEntry {
+	t.Helper()
+	line := fmt.Sprintf(`10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET %s HTTP/1.1" 200 15 "%s" "curl"`, url, referrer)
+	pe, err := combinedLogLineParser(line)
+	if err != nil {
+		t.Fatalf("combinedLogLineParser(%q) error = %v", line, err)
+	}
+	return pe
+}
+
+func TestURLDerivations(t *testing.T) {
+	tests := []struct {
+		url   string
+		field string
+		want  string
+	}{
+		{"/docs/a%20b.tar.gz?q=caf%C3%A9", "path", "/docs/a b.tar.gz"},
+		{"/docs/a%20b.tar.gz?q=caf%C3%A9", "ext", "gz"},
+		{"/docs/a%20b.tar.gz?q=caf%C3%A9", "query", "q=café"},
+		{"/docs/a%20b.tar.gz?q=caf%C3%A9", "param[q]", "café"},
+		{"/index.html", "query", ""},
+		{"/index.html", "param[q]", ""},
+		{"/index.html", "ext", "html"},
+		{"/feed/", "ext", ""},
+		{"/search?q=%zz", "query", "q=%zz"},
+		{"/search?q=%zz", "param[q]", ""},
+		{"/search?tag=a&tag=b", "param[tag]", "a"},
+		{"/search?tag=&x=1", "param[tag]", ""},
+		// a bad escape in the path doesn't parse at all
+		{"/a%zz.php?q=1", "path", ""},
+		{"/a%zz.php?q=1", "ext", ""},
+		{"/a%zz.php?q=1", "param[q]", ""},
+		{"*", "path", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url+" "+tt.field, func(t *testing.T) {
+			if got := entryWith(t, tt.url, "-").Field(tt.field); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferrerDerivations(t *testing.T) {
+	saved := siteHosts
+	defer func() { siteHosts = saved }()
+	siteHosts = map[string]bool{}
+	addSiteHosts("Example.COM, www.example.com")
+
+	tests := []struct {
+		referrer                                 string
+		refhost, refpath, refscheme, refexternal string
+	}{
+		{"-", "", "", "", "false"},
+		{"", "", "", "", "false"},
+		{"https://Example.com/start?x=1", "example.com", "/start", "https", "false"},
+		{"HTTP://WWW.EXAMPLE.COM/", "www.example.com", "/", "http", "false"},
+		{"https://search.example.org/q?s=combined", "search.example.org", "/q", "https", "true"},
+		{"https://example.com:8443/a%20b", "example.com", "/a b", "https", "false"},
+		{"android-app://com.google.android.gm/", "com.google.android.gm", "/", "android-app", "true"},
+		// no scheme, or no host, or no parse at all
+		{"example.com/start", "", "", "", "false"},
+		{"/start", "", "", "", "false"},
+		{"mailto:someone@example.org", "", "", "", "false"},
+		{"http://[::1", "", "", "", "false"},
+		{"https://example.org/%zz", "", "", "", "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.referrer, func(t *testing.T) {
+			pe := entryWith(t, "/", tt.referrer)
+			for field, want := range map[string]string{
+				"refhost":     tt.refhost,
+				"refpath":     tt.refpath,
+				"refscheme":   tt.refscheme,
+				"refexternal": tt.refexternal,
+			} {
+				if got := pe.Field(field); got != want {
+					t.Errorf("%s = %q, want %q", field, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"combined/parser"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// Exported, typed accessors of *parsedEntry fields, for -template
+// output. Fields that a log line format lacks come back as empty
+// strings, or zero values.
+
+// Line is the entire, original log file line
+func (pe *parsedEntry) Line() string { return pe.line }
+
+func (pe *parsedEntry) IPAddr() string    { return pe.fields[0] }
+func (pe *parsedEntry) User() string      { return pe.fields[1] }
+func (pe *parsedEntry) Method() string    { return pe.fields[3] }
+func (pe *parsedEntry) URL() string       { return pe.fields[4] }
+func (pe *parsedEntry) Version() string   { return pe.fields[5] }
+func (pe *parsedEntry) Referrer() string  { return pe.fields[8] }
+func (pe *parsedEntry) UserAgent() string { return pe.fields[9] }
+func (pe *parsedEntry) Ident() string     { return pe.field(10) }
+
+// Timestamp is the zero time.Time if the timestamp doesn't parse.
+func (pe *parsedEntry) Timestamp() time.Time {
+	t, _ := pe.timestamp()
+	return t
+}
+
+// Code is the HTTP status code, 0 if it's not a number
+func (pe *parsedEntry) Code() int {
+	n, _ := strconv.Atoi(pe.fields[6])
+	return n
+}
+
+// Size is the count of bytes sent, 0 if the log line has "-"
+func (pe *parsedEntry) Size() int64 {
+	n, _ := strconv.ParseInt(pe.fields[7], 10, 64)
+	return n
+}
+
+// Duration is how long the request took, 0 if the
+// log line doesn't have it.
+func (pe *parsedEntry) Duration() time.Duration {
+	n, _ := strconv.ParseInt(pe.field(durationIndex), 10, 64)
+	return time.Duration(n) * time.Microsecond
+}
+
+// Field finds the value of any field by name, including fields
+// without their own accessor method, like "vhost" or "duration".
+// Family members that nothing registered before input started,
+// like a param[name] only a template mentions, get computed here:
+// registering them now would be too late for deriverFor.
+func (pe *parsedEntry) Field(name string) string {
+	if n, ok := parser.FieldToIndex[name]; ok {
+		return pe.field(n)
+	}
+	if family, arg, ok := parser.SplitFamily(name); ok {
+		if derive, ok := familyDerivations[family]; ok {
+			return derive(pe, arg)
+		}
+	}
+	return ""
+}
+
+// field finds the value of field n, computing derived fields the
+// first time something asks for them. Fields past the end of
+// pe.fields, which some line formats don't fill in, are empty.
+func (pe *parsedEntry) field(n int) string {
+	if derive := deriverFor(n); derive != nil {
+		value, ok := pe.derived[n]
+		if !ok {
+			if pe.derived == nil {
+				pe.derived = make(map[int]string)
+			}
+			value = derive(pe)
+			pe.derived[n] = value
+		}
+		return value
+	}
+	if n >= len(pe.fields) {
+		return ""
+	}
+	return pe.fields[n]
+}
+
+// Value, Time and Addr make a *parsedEntry a vm.Record,
+// so compiled match sentences can run against it.
+
+func (pe *parsedEntry) Value(n int) string        { return pe.field(n) }
+func (pe *parsedEntry) Time() (time.Time, error)  { return pe.timestamp() }
+func (pe *parsedEntry) Addr() (netip.Addr, error) { return pe.addr() }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"combined/parser"
+	"testing"
+)
+
+func TestParsedEntry_Field(t *testing.T) {
+	// as if -e or -j had settled the derived fields already
+	deriverFor(0)
+
+	pe, err := combinedLogLineParser(`10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET /a.html?utm_campaign=fall&x=1 HTTP/1.1" 200 15 "-" "curl" 1234`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"code", "200"},
+		{"garbage", "-"},
+		{"duration", "1234"},
+		{"ext", "html"},
+		{"param[utm_campaign]", "fall"},
+		{"param[x]", "1"},
+		{"param[missing]", ""},
+		{"nonesuch", ""},
+		{"nonesuch[x]", ""},
+	}
+	for _, tt := range tests {
+		if got := pe.Field(tt.name); got != tt.want {
+			t.Errorf("Field(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := parser.FieldToIndex["param[utm_campaign]"]; ok {
+		t.Errorf("Field() registered param[utm_campaign]")
+	}
+}
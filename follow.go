@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// followPoll is how long a followReader waits at end of file
+// before looking for more lines, or for a rotated log file.
+// Tests make it shorter.
+var followPoll = time.Second
+
+// followReader reads a log file the way "tail -F" does: at end of
+// file, it waits for more data instead of returning io.EOF. When
+// logrotate renames the file and a new one appears under the same
+// name, or when something truncates the file, it starts reading
+// from the beginning of the new or truncated file.
+type followReader struct {
+	name   string
+	file   *os.File
+	offset int64
+}
+
+func newFollowReader(fin *os.File) *followReader {
+	return &followReader{name: fin.Name(), file: fin}
+}
+
+// Read never returns io.EOF, so a bufio.Scanner reading from
+// a *followReader keeps scanning until some other error.
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.file.Read(p)
+		fr.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		reopened, err := fr.checkRotation()
+		if err != nil {
+			return 0, err
+		}
+		if !reopened {
+			time.Sleep(followPoll)
+		}
+	}
+}
+
+// checkRotation compares the file being read to whatever file
+// has its name now. Reports whether it switched to reading a
+// different file, or the beginning of a truncated file.
+func (fr *followReader) checkRotation() (bool, error) {
+	current, err := fr.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	onDisk, err := os.Stat(fr.name)
+	if err != nil {
+		// renamed, and a new file isn't there yet
+		return false, nil
+	}
+
+	if !os.SameFile(current, onDisk) {
+		fin, err := os.Open(fr.name)
+		if err != nil {
+			return false, nil
+		}
+		fr.file.Close()
+		fr.file = fin
+		fr.offset = 0
+		return true, nil
+	}
+
+	if current.Size() < fr.offset {
+		if _, err := fr.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		fr.offset = 0
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Close closes whatever file fr has open at the moment. That may not
+// be the file fr started out with, which rotation already closed.
+func (fr *followReader) Close() error {
+	if err := fr.file.Close(); !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowReader(t *testing.T) {
+	defer func(poll time.Duration) { followPoll = poll }(followPoll)
+	followPoll = 10 * time.Millisecond
+
+	name := filepath.Join(t.TempDir(), "access_log")
+	writeFile := func(text string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("one\ntwo\n")
+
+	fin, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := newFollowReader(fin)
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(fr)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	defer func() {
+		// closing the file stops the scanner
+		fr.Close()
+		for range lines {
+		}
+	}()
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got, ok := <-lines:
+				if !ok {
+					t.Fatalf("scanner stopped, wanted %q", w)
+				}
+				if got != w {
+					t.Fatalf("got line %q, want %q", got, w)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %q", w)
+			}
+		}
+	}
+
+	expect("one", "two")
+
+	// appended lines show up without any end of file
+	fout, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.WriteString("three\n")
+	fout.Close()
+	expect("three")
+
+	// logrotate renames the file, the server starts a new one
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile("four\nfive\nsix\n")
+	expect("four", "five", "six")
+
+	// truncated to shorter than what's been read, then written
+	writeFile("seven\n")
+	expect("seven")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"combined/logformat"
+	"combined/parser"
+)
+
+// formatLineParser registers the fields that a LogFormat produces,
+// and returns a function that breaks lines in that format into a
+// *parsedEntry, the way combinedLogLineParser does for "combined"
+// format lines. Any "combined" field that the LogFormat lacks
+// stays an empty string.
+func formatLineParser(f *logformat.Format) func(string) (*parsedEntry, error) {
+	indexes := make([]int, len(f.Fields))
+	for i, field := range f.Fields {
+		indexes[i] = parser.RegisterField(field.Name)
+		// %q, say, logs what would otherwise be derived
+		delete(derivations, field.Name)
+		if field.Numeric {
+			parser.NumericFields[field.Name] = true
+		}
+	}
+	parser.AllFieldsIndexes = indexes
+	width := len(parser.FieldNames)
+
+	return func(line string) (*parsedEntry, error) {
+		values, err := f.Split(line)
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]string, width)
+		for i := range values {
+			fields[indexes[i]] = values[i]
+		}
+		return &parsedEntry{line: line, fields: fields}, nil
+	}
+}
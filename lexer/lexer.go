@@ -21,6 +21,20 @@ const (
 	MATCH_OP
 	EXACT_MATCH
 	REGEX_MATCH
+	NUMERIC_COMPARE
+	LESS_THAN
+	LESS_EQUAL
+	GREATER_THAN
+	GREATER_EQUAL
+	NOT_EQUAL
+	TIME_COMPARE
+	TIME_RANGE
+	CIDR_MATCH
+	PREDICATE
+	SET_MATCH
+	LIST
+	FILE
+	ERROR
 	EOL
 )
 
@@ -40,6 +54,34 @@ func (t TokenType) String() string {
 		return "EXACT_MATCH"
 	case REGEX_MATCH:
 		return "REGEX_MATCH"
+	case NUMERIC_COMPARE:
+		return "NUMERIC_COMPARE"
+	case LESS_THAN:
+		return "LESS_THAN"
+	case LESS_EQUAL:
+		return "LESS_EQUAL"
+	case GREATER_THAN:
+		return "GREATER_THAN"
+	case GREATER_EQUAL:
+		return "GREATER_EQUAL"
+	case NOT_EQUAL:
+		return "NOT_EQUAL"
+	case TIME_COMPARE:
+		return "TIME_COMPARE"
+	case TIME_RANGE:
+		return "TIME_RANGE"
+	case CIDR_MATCH:
+		return "CIDR_MATCH"
+	case PREDICATE:
+		return "PREDICATE"
+	case SET_MATCH:
+		return "SET_MATCH"
+	case LIST:
+		return "LIST"
+	case FILE:
+		return "FILE"
+	case ERROR:
+		return "ERROR"
 	case AND:
 		return "AND"
 	case OR:
@@ -111,7 +153,7 @@ func (l *Lexer) run() {
 func lexWhiteSpace(l *Lexer) stateFn {
 	for _, r := range l.input[l.start:] {
 		switch r {
-		case ' ', '"', '\'', '\t':
+		case ' ', '"', '\'', '\t', '\r':
 			l.pos++
 			l.start++
 		default:
@@ -138,18 +180,31 @@ func (l *Lexer) nextStateFn() stateFn {
 		return lexAmpersand
 	case '-':
 		return lexMinus
-	case '=', '~':
+	case '=', '~', '@':
 		return lexMatchOp
+	case '<', '>', '!':
+		return lexCompareOp
 	case '\n':
 		return lexEOL
+	case ' ', '"', '\'', '\t', '\r':
+		return lexWhiteSpace
 	default:
 		if unicode.IsLetter(l.input[l.pos]) {
 			return lexField
 		}
-		return lexWhiteSpace
+		return lexError
 	}
 }
 
+// lexError makes a token of a character that can't start
+// any other token, like a stray ',', so the parser can
+// complain about it.
+func lexError(l *Lexer) stateFn {
+	l.pos++
+	l.emit(ERROR)
+	return l.nextStateFn()
+}
+
 func (l *Lexer) emit(t TokenType) {
 	l.items <- item{t, string(l.input[l.start:l.pos])}
 	l.start = l.pos
@@ -163,14 +218,95 @@ func identifierChar(r rune) bool {
 	return unicode.IsDigit(r) || unicode.IsLetter(r) || r == '_'
 }
 
+// lexField finds field names, including parameterized ones like
+// param[utm_source], and the "in" keyword, which is a match-op
+// that happens to look like a field.
 func lexField(l *Lexer) stateFn {
 	for l.pos < len(l.input) && identifierChar(rune(l.input[l.pos])) {
 		l.pos++
 	}
+	if string(l.input[l.start:l.pos]) == "in" {
+		l.emit(MATCH_OP)
+		return lexAfterIn
+	}
+	if l.pos < len(l.input) && l.input[l.pos] == '[' {
+		for l.pos < len(l.input) && l.input[l.pos] != ']' {
+			l.pos++
+		}
+		if l.pos < len(l.input) {
+			l.pos++
+		}
+	}
 	l.emit(FIELD)
 	return l.nextStateFn()
 }
 
+// lexAfterIn looks for the set of values that can follow the "in"
+// keyword, a [...] list, or an @file of values, one per line.
+// Otherwise, "in" has an ordinary pattern, a time range, after it.
+// '@' means a file here, not the network prefix match-op.
+func lexAfterIn(l *Lexer) stateFn {
+	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.pos++
+	}
+	l.start = l.pos
+	if l.pos < len(l.input) {
+		switch l.input[l.pos] {
+		case '[':
+			return lexList
+		case '@':
+			return lexFileName
+		}
+	}
+	return l.nextStateFn()
+}
+
+// lexList finds a list of values, brackets included. A ']' or ','
+// inside a quoted value, like "/a[1]", doesn't count. Quotes only
+// start a quoted value at the beginning of a value, so that values
+// like O'Brien don't need quoting.
+func lexList(l *Lexer) stateFn {
+	var quote rune
+	atValue := true // nothing but white space since '[' or ','
+	l.pos++         // we know l.input[l.pos] == '['
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		l.pos++
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == ']':
+			l.emit(LIST)
+			return l.nextStateFn()
+		case atValue && (r == '"' || r == '\''):
+			quote = r
+			atValue = false
+		case r == ',':
+			atValue = true
+		case r != ' ' && r != '\t':
+			atValue = false
+		}
+	}
+	l.emit(LIST)
+	return l.nextStateFn()
+}
+
+// lexFileName finds an '@' and the file name after it,
+// which ends at white space or a right paren.
+func lexFileName(l *Lexer) stateFn {
+	l.pos++
+	for l.pos < len(l.input) {
+		if r := l.input[l.pos]; r == ' ' || r == '\t' || r == '\n' || r == ')' {
+			break
+		}
+		l.pos++
+	}
+	l.emit(FILE)
+	return l.nextStateFn()
+}
+
 func lexLeftParen(l *Lexer) stateFn {
 	l.pos++
 	l.emit(LPAREN)
@@ -189,6 +325,17 @@ func lexMatchOp(l *Lexer) stateFn {
 	return l.nextStateFn()
 }
 
+// lexCompareOp handles '<', '>', '!' and any '=' that
+// follows them, making "<=", ">=" and "!=" single tokens.
+func lexCompareOp(l *Lexer) stateFn {
+	l.pos++
+	if l.pos < len(l.input) && l.input[l.pos] == '=' {
+		l.pos++
+	}
+	l.emit(MATCH_OP)
+	return l.nextStateFn()
+}
+
 func lexMinus(l *Lexer) stateFn {
 	l.pos++
 	l.emit(NOT)
@@ -207,12 +354,18 @@ func lexPipe(l *Lexer) stateFn {
 	return l.nextStateFn()
 }
 
+// lexSlash finds a slash-delimited pattern. A slash followed
+// by a digit doesn't end the pattern, so that network prefixes
+// like /10.0.0.0/8/ don't need their slash escaped.
 func lexSlash(l *Lexer) stateFn {
 	var escaping bool
 	l.pos++ // we know l.input[l.pos] == '/'
 	for l.pos < len(l.input) {
 		if !escaping && rune(l.input[l.pos]) == '/' {
 			l.pos++
+			if l.pos < len(l.input) && unicode.IsDigit(l.input[l.pos]) {
+				continue
+			}
 			break
 		}
 
@@ -35,6 +35,34 @@ func TestTokenType_String(t *testing.T) {
 			name: "EXACT_MATCH token type", tr: EXACT_MATCH, want: "EXACT_MATCH"},
 		{
 			name: "REGEX_MATCH token type", tr: REGEX_MATCH, want: "REGEX_MATCH"},
+		{
+			name: "NUMERIC_COMPARE token type", tr: NUMERIC_COMPARE, want: "NUMERIC_COMPARE"},
+		{
+			name: "LESS_THAN token type", tr: LESS_THAN, want: "LESS_THAN"},
+		{
+			name: "LESS_EQUAL token type", tr: LESS_EQUAL, want: "LESS_EQUAL"},
+		{
+			name: "GREATER_THAN token type", tr: GREATER_THAN, want: "GREATER_THAN"},
+		{
+			name: "GREATER_EQUAL token type", tr: GREATER_EQUAL, want: "GREATER_EQUAL"},
+		{
+			name: "NOT_EQUAL token type", tr: NOT_EQUAL, want: "NOT_EQUAL"},
+		{
+			name: "TIME_COMPARE token type", tr: TIME_COMPARE, want: "TIME_COMPARE"},
+		{
+			name: "TIME_RANGE token type", tr: TIME_RANGE, want: "TIME_RANGE"},
+		{
+			name: "CIDR_MATCH token type", tr: CIDR_MATCH, want: "CIDR_MATCH"},
+		{
+			name: "PREDICATE token type", tr: PREDICATE, want: "PREDICATE"},
+		{
+			name: "SET_MATCH token type", tr: SET_MATCH, want: "SET_MATCH"},
+		{
+			name: "LIST token type", tr: LIST, want: "LIST"},
+		{
+			name: "FILE token type", tr: FILE, want: "FILE"},
+		{
+			name: "ERROR token type", tr: ERROR, want: "ERROR"},
 		{
 			name: "EOL token type", tr: EOL, want: "EOL"},
 	}
@@ -96,12 +124,60 @@ func TestLexer_NextToken(t *testing.T) {
 			wantType:    MATCH_OP,
 			wantLexeme:  "~",
 		},
+		{
+			name:        "less than token",
+			singleToken: "<",
+			wantType:    MATCH_OP,
+			wantLexeme:  "<",
+		},
+		{
+			name:        "less than or equal token",
+			singleToken: "<=",
+			wantType:    MATCH_OP,
+			wantLexeme:  "<=",
+		},
+		{
+			name:        "greater than token",
+			singleToken: ">",
+			wantType:    MATCH_OP,
+			wantLexeme:  ">",
+		},
+		{
+			name:        "greater than or equal token",
+			singleToken: ">=",
+			wantType:    MATCH_OP,
+			wantLexeme:  ">=",
+		},
+		{
+			name:        "not equal token",
+			singleToken: "!=",
+			wantType:    MATCH_OP,
+			wantLexeme:  "!=",
+		},
+		{
+			name:        "in token",
+			singleToken: "in",
+			wantType:    MATCH_OP,
+			wantLexeme:  "in",
+		},
+		{
+			name:        "network prefix match token",
+			singleToken: "@",
+			wantType:    MATCH_OP,
+			wantLexeme:  "@",
+		},
 		{
 			name:        "field token",
 			singleToken: "timestamp",
 			wantType:    FIELD,
 			wantLexeme:  "timestamp",
 		},
+		{
+			name:        "parameterized field token",
+			singleToken: "param[utm_source]",
+			wantType:    FIELD,
+			wantLexeme:  "param[utm_source]",
+		},
 		{
 			name:        "regexp pattern token",
 			singleToken: "/abcdefg/",
@@ -196,6 +272,97 @@ func TestLexer_NextTokenStream(t *testing.T) {
 				testItem{RPAREN, ")"},
 			},
 		},
+		{
+			name:        "numeric comparisons",
+			tokenString: "code>=/500/ && size</1000/",
+			wantItems: []testItem{
+				testItem{FIELD, "code"},
+				testItem{MATCH_OP, ">="},
+				testItem{PATTERN, "/500/"},
+				testItem{AND, "&&"},
+				testItem{FIELD, "size"},
+				testItem{MATCH_OP, "<"},
+				testItem{PATTERN, "/1000/"},
+			},
+		},
+		{
+			name:        "time range",
+			tokenString: "timestamp in /2024-05-01T14:00Z..2024-05-01T15:30Z/",
+			wantItems: []testItem{
+				testItem{FIELD, "timestamp"},
+				testItem{MATCH_OP, "in"},
+				testItem{PATTERN, "/2024-05-01T14:00Z..2024-05-01T15:30Z/"},
+			},
+		},
+		{
+			name:        "network prefixes",
+			tokenString: "ipaddr @ /10.0.0.0/8, 2001:db8::/32/ || url~/a/",
+			wantItems: []testItem{
+				testItem{FIELD, "ipaddr"},
+				testItem{MATCH_OP, "@"},
+				testItem{PATTERN, "/10.0.0.0/8, 2001:db8::/32/"},
+				testItem{OR, "||"},
+				testItem{FIELD, "url"},
+				testItem{MATCH_OP, "~"},
+				testItem{PATTERN, "/a/"},
+			},
+		},
+		{
+			name:        "set membership, list",
+			tokenString: "ipaddr in [1.2.3.4, 5.6.7.8] && method in[GET,HEAD]",
+			wantItems: []testItem{
+				testItem{FIELD, "ipaddr"},
+				testItem{MATCH_OP, "in"},
+				testItem{LIST, "[1.2.3.4, 5.6.7.8]"},
+				testItem{AND, "&&"},
+				testItem{FIELD, "method"},
+				testItem{MATCH_OP, "in"},
+				testItem{LIST, "[GET,HEAD]"},
+			},
+		},
+		{
+			name:        "set membership, quoted list",
+			tokenString: `url in ["/a[1]", 'x, y'] && user in [O'Brien]`,
+			wantItems: []testItem{
+				testItem{FIELD, "url"},
+				testItem{MATCH_OP, "in"},
+				testItem{LIST, `["/a[1]", 'x, y']`},
+				testItem{AND, "&&"},
+				testItem{FIELD, "user"},
+				testItem{MATCH_OP, "in"},
+				testItem{LIST, "[O'Brien]"},
+			},
+		},
+		{
+			name:        "stray characters",
+			tokenString: "url = /a/, code = 200",
+			wantItems: []testItem{
+				testItem{FIELD, "url"},
+				testItem{MATCH_OP, "="},
+				testItem{PATTERN, "/a/"},
+				testItem{ERROR, ","},
+				testItem{FIELD, "code"},
+				testItem{MATCH_OP, "="},
+				testItem{ERROR, "2"},
+				testItem{ERROR, "0"},
+				testItem{ERROR, "0"},
+			},
+		},
+		{
+			name:        "set membership, file",
+			tokenString: "(ipaddr in @/etc/blocklist.txt) || ipaddr @ /10.0.0.0/8/",
+			wantItems: []testItem{
+				testItem{LPAREN, "("},
+				testItem{FIELD, "ipaddr"},
+				testItem{MATCH_OP, "in"},
+				testItem{FILE, "@/etc/blocklist.txt"},
+				testItem{RPAREN, ")"},
+				testItem{OR, "||"},
+				testItem{FIELD, "ipaddr"},
+				testItem{MATCH_OP, "@"},
+				testItem{PATTERN, "/10.0.0.0/8/"},
+			},
+		},
 		{
 			name:        "difficult metacharacters",
 			tokenString: `url=/http:\/\/bruceediger\.com\//`,
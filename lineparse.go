@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// combinedLogLineParser breaks a "combined" format line into its
+// fields in a single pass, left to right. Fields are sub-strings of
+// line, not copies. Quoted fields can have backslash-escaped double
+// quotes in them, the way httpd logs a user agent with a '"' in it,
+// and keep the backslashes.
+func combinedLogLineParser(line string) (*parsedEntry, error) {
+	s := lineScanner{line: line}
+
+	ipaddr := s.word()
+	if ipaddr == "" || !s.skip(' ') {
+		return nil, s.fail("IP address")
+	}
+	ident := s.word()
+	if ident == "" || !s.skip(' ') {
+		return nil, s.fail("identd user")
+	}
+	user := s.word()
+	if !s.skip(' ') {
+		return nil, s.fail("authenticated user")
+	}
+	timestamp, ok := s.bracketed()
+	if !ok || !s.skip(' ') {
+		return nil, s.fail("timestamp")
+	}
+	request, ok := s.quoted()
+	if !ok || !s.skip(' ') {
+		return nil, s.fail("request")
+	}
+	code := s.digits()
+	if code == "" || !s.skip(' ') {
+		return nil, s.fail("status code")
+	}
+	size := s.digits()
+	if size == "" || !s.skip(' ') {
+		return nil, s.fail("size")
+	}
+	referrer, ok := s.quoted()
+	if !ok || !s.skip(' ') {
+		return nil, s.fail("referrer")
+	}
+	userAgent, ok := s.quoted()
+	if !ok {
+		return nil, s.fail("user agent")
+	}
+	var duration string
+	if s.skip(' ') {
+		// %D, request duration in microseconds
+		if duration = s.digits(); duration == "" {
+			return nil, s.fail("duration")
+		}
+	}
+	if s.pos != len(line) {
+		return nil, s.fail("end of line")
+	}
+
+	method, url, version := requestParts(request)
+	pe := &parsedEntry{
+		line: line,
+		fields: []string{
+			ipaddr,
+			user,
+			timestamp,
+			method,
+			url,
+			version,
+			code,
+			size,
+			referrer,
+			userAgent,
+			ident,
+		},
+	}
+	if duration != "" {
+		// duration's index is past the derived fields
+		pe.fields = append(pe.fields, make([]string, durationIndex+1-len(pe.fields))...)
+		pe.fields[durationIndex] = duration
+	}
+	return pe, nil
+}
+
+// requestParts splits a request like "GET /index.html HTTP/1.1" into
+// method, URL and HTTP version. A request with fewer than three
+// parts, like the junk that port scanners send, has none of them.
+func requestParts(request string) (method, url, version string) {
+	var parts [3]string
+	for i := range parts {
+		request = strings.TrimLeft(request, " \t")
+		if request == "" {
+			return "", "", ""
+		}
+		end := strings.IndexAny(request, " \t")
+		if end < 0 {
+			end = len(request)
+		}
+		parts[i], request = request[:end], request[end:]
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// lineScanner keeps track of how far into a log line
+// combinedLogLineParser has gotten.
+type lineScanner struct {
+	line string
+	pos  int
+}
+
+// skip steps over c, if c is next in the line.
+func (s *lineScanner) skip(c byte) bool {
+	if s.pos < len(s.line) && s.line[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// word finds everything up to the next space, or the end of the line.
+func (s *lineScanner) word() string {
+	start := s.pos
+	for s.pos < len(s.line) && s.line[s.pos] != ' ' {
+		s.pos++
+	}
+	return s.line[start:s.pos]
+}
+
+// digits finds a run of decimal digits, maybe an empty one.
+func (s *lineScanner) digits() string {
+	start := s.pos
+	for s.pos < len(s.line) && s.line[s.pos] >= '0' && s.line[s.pos] <= '9' {
+		s.pos++
+	}
+	return s.line[start:s.pos]
+}
+
+// bracketed finds a non-empty "[...]" timestamp, brackets included.
+func (s *lineScanner) bracketed() (string, bool) {
+	if s.pos >= len(s.line) || s.line[s.pos] != '[' {
+		return "", false
+	}
+	end := strings.IndexByte(s.line[s.pos+1:], ']')
+	if end < 1 {
+		return "", false
+	}
+	start := s.pos
+	s.pos += end + 2
+	return s.line[start:s.pos], true
+}
+
+// quoted finds the contents of a double-quoted field. A backslash
+// escapes the character after it, so `\"` doesn't end the field.
+func (s *lineScanner) quoted() (string, bool) {
+	if s.pos >= len(s.line) || s.line[s.pos] != '"' {
+		return "", false
+	}
+	for i := s.pos + 1; i < len(s.line); i++ {
+		switch s.line[i] {
+		case '\\':
+			i++
+		case '"':
+			value := s.line[s.pos+1 : i]
+			s.pos = i + 1
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// fail describes where a line stops looking like "combined" format.
+func (s *lineScanner) fail(what string) error {
+	return fmt.Errorf("no %s at column %d", what, s.pos+1)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCombinedLogLineParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "combined format",
+			line: `192.168.1.10 - frank [10/Oct/2023:13:55:36 -0700] "GET /index.html?a=1 HTTP/1.1" 200 2326 "http://example.com/start" "Mozilla/5.0 (X11; Linux x86_64)"`,
+			want: []string{"192.168.1.10", "frank", "[10/Oct/2023:13:55:36 -0700]", "GET", "/index.html?a=1", "HTTP/1.1", "200", "2326", "http://example.com/start", "Mozilla/5.0 (X11; Linux x86_64)", "-"},
+		},
+		{
+			name: "escaped quotes in user agent",
+			line: `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.0" 404 0 "-" "Bad \"Agent\" \\ 1.0"`,
+			want: []string{"10.0.0.5", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.0", "404", "0", "-", `Bad \"Agent\" \\ 1.0`, "-"},
+		},
+		{
+			name: "junk request",
+			line: `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "\x16\x03\x01" 400 226 "-" "-"`,
+			want: []string{"10.0.0.5", "-", "[10/Oct/2023:13:55:36 +0000]", "", "", "", "400", "226", "-", "-", "-"},
+		},
+		{
+			name: "empty user",
+			line: `::1 id  [10/Oct/2023:13:55:36 +0000] "" 408 0 "-" "-"`,
+			want: []string{"::1", "", "[10/Oct/2023:13:55:36 +0000]", "", "", "", "408", "0", "-", "-", "id"},
+		},
+		{
+			name:    "common format",
+			line:    `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.0" 200 15`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated user agent",
+			line:    `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.0" 200 15 "-" "curl\"`,
+			wantErr: true,
+		},
+		{
+			name:    "size of -",
+			line:    `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.0" 304 - "-" "curl"`,
+			wantErr: true,
+		},
+		{
+			name:    "trailing junk",
+			line:    `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.0" 200 15 "-" "curl" slow`,
+			wantErr: true,
+		},
+		{
+			name:    "empty line",
+			line:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := combinedLogLineParser(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("combinedLogLineParser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.fields, tt.want) {
+				t.Errorf("combinedLogLineParser() = %q, want %q", got.fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinedLogLineParser_Duration(t *testing.T) {
+	line := `10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] "GET / HTTP/1.0" 200 15 "-" "curl" 1234`
+	pe, err := combinedLogLineParser(line)
+	if err != nil {
+		t.Fatalf("combinedLogLineParser() error = %v", err)
+	}
+	if got := pe.field(durationIndex); got != "1234" {
+		t.Errorf("duration = %q, want 1234", got)
+	}
+	if got := pe.field(6); got != "200" {
+		t.Errorf("code = %q, want 200", got)
+	}
+}
+
+// regexpLogLineParser is how combinedLogLineParser used to work,
+// kept to check that the byte scanner agrees with it, and is faster.
+var logLineXX = regexp.MustCompile(`^([^ ]+) ([^ ]+) ([^ ]*) (\[[^]]+\]) "([^"]*)" (\d{1,}) (\d{1,}) "([^"]*)" "([^"]*)"(?: (\d+))?$`)
+
+func regexpLogLineParser(line string) []string {
+	text := strings.ReplaceAll(line, `\"`, "''")
+	matches := logLineXX.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	m := matches[0]
+	var method, url, version string
+	if fields := strings.Fields(m[5]); len(fields) > 2 {
+		method, url, version = fields[0], fields[1], fields[2]
+	}
+	return []string{m[1], m[3], m[4], method, url, version, m[6], m[7], m[8], m[9], m[2]}
+}
+
+var benchmarkLines = []string{
+	`192.168.1.10 - frank [10/Oct/2023:13:55:36 -0700] "GET /index.html?a=1 HTTP/1.1" 200 2326 "http://example.com/start" "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0"`,
+	`66.249.66.1 - - [10/Oct/2023:13:55:37 -0700] "GET /robots.txt HTTP/1.1" 404 196 "-" "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"`,
+	`2001:db8::1 - - [10/Oct/2023:13:55:38 -0700] "POST /wp-login.php HTTP/1.1" 200 5120 "-" "python-requests/2.31.0"`,
+	`10.0.0.5 - - [10/Oct/2023:13:55:39 -0700] "GET /feed HTTP/1.1" 304 0 "https://www.example.com/" "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1" 5321`,
+}
+
+func TestCombinedLogLineParser_SameAsRegexp(t *testing.T) {
+	for _, line := range benchmarkLines[:3] {
+		pe, err := combinedLogLineParser(line)
+		if err != nil {
+			t.Fatalf("combinedLogLineParser(%q) error = %v", line, err)
+		}
+		if want := regexpLogLineParser(line); !reflect.DeepEqual(pe.fields, want) {
+			t.Errorf("combinedLogLineParser() = %q, regexp gives %q", pe.fields, want)
+		}
+	}
+}
+
+func BenchmarkCombinedLogLineParser(b *testing.B) {
+	benchmarkParser(b, func(line string) bool {
+		_, err := combinedLogLineParser(line)
+		return err == nil
+	})
+}
+
+func BenchmarkRegexpLogLineParser(b *testing.B) {
+	benchmarkParser(b, func(line string) bool {
+		return regexpLogLineParser(line) != nil
+	})
+}
+
+func benchmarkParser(b *testing.B, parse func(string) bool) {
+	size := 0
+	for _, line := range benchmarkLines {
+		size += len(line)
+	}
+	b.SetBytes(int64(size / len(benchmarkLines)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if !parse(benchmarkLines[i%len(benchmarkLines)]) {
+			b.Fatal("line did not parse")
+		}
+	}
+}
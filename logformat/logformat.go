@@ -0,0 +1,307 @@
+package logformat
+
+// Turn an Apache httpd LogFormat directive, like
+// "%h %l %u %t \"%r\" %>s %b", into something that
+// breaks log file lines in that format into named fields.
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Field describes one named piece of a log line.
+type Field struct {
+	Name    string
+	Numeric bool
+}
+
+// Format holds a compiled LogFormat string: a regular expression
+// that matches a whole log line, and which field each of the
+// regular expression's sub-matches fills in.
+type Format struct {
+	Spec   string
+	Fields []Field
+	re     *regexp.Regexp
+	pieces []piece
+}
+
+// piece tells Split what to do with one sub-match of the regexp.
+type piece struct {
+	field   int // index into Fields, -1 for the %r request line
+	request [3]int
+	convert func(string) string
+}
+
+// directive describes what a single %-letter of LogFormat produces.
+type directive struct {
+	name    string
+	numeric bool
+	pattern string
+	convert func(string) string
+}
+
+// anyField matches an unquoted field: everything up to a space.
+const anyField = `(\S*)`
+
+// quotedField matches the contents of a double-quoted field,
+// with httpd's backslash escapes.
+const quotedField = `((?:[^"\\]|\\.)*)`
+
+var directives = map[byte]directive{
+	'a': {name: "ipaddr"},
+	'A': {name: "localaddr"},
+	'b': {name: "size", numeric: true},
+	'B': {name: "size", numeric: true},
+	'D': {name: "duration", numeric: true},
+	'f': {name: "filename"},
+	'h': {name: "ipaddr"},
+	'H': {name: "version"},
+	'I': {name: "received", numeric: true},
+	'k': {name: "keepalives", numeric: true},
+	'l': {name: "ident"},
+	'L': {name: "logid"},
+	'm': {name: "method"},
+	'O': {name: "size", numeric: true},
+	'p': {name: "port", numeric: true},
+	'P': {name: "pid", numeric: true},
+	'q': {name: "query"},
+	'R': {name: "handler"},
+	's': {name: "code", numeric: true},
+	'S': {name: "transferred", numeric: true},
+	't': {name: "timestamp", pattern: `(\[[^]]+\])`},
+	'T': {name: "duration", numeric: true, convert: secondsToMicros},
+	'u': {name: "user"},
+	'U': {name: "url"},
+	'v': {name: "vhost"},
+	'V': {name: "vhost"},
+	'X': {name: "connstatus"},
+}
+
+// requestFields are the fields that a %r directive fills in
+var requestFields = [3]string{"method", "url", "version"}
+
+// headerNames gives the historical field names to
+// the request headers that "combined" format logs.
+var headerNames = map[string]string{
+	"referer":    "referrer",
+	"user_agent": "useragent",
+}
+
+// Compile parses a LogFormat string into a *Format.
+// Backslash escapes in spec get interpreted the way httpd's
+// configuration file reader does, so \" is a double quote.
+func Compile(spec string) (*Format, error) {
+	f := &Format{Spec: spec}
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	text := unescape(spec)
+	for i := 0; i < len(text); i++ {
+		if text[i] != '%' {
+			expr.WriteString(regexp.QuoteMeta(text[i : i+1]))
+			continue
+		}
+		i++
+		if i < len(text) && text[i] == '%' {
+			expr.WriteString("%")
+			continue
+		}
+
+		// status code conditions and the < and > modifiers
+		// change what httpd logs, not the shape of the line
+		for i < len(text) && strings.IndexByte("<>!,0123456789", text[i]) >= 0 {
+			i++
+		}
+
+		var arg string
+		if i < len(text) && text[i] == '{' {
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %%{ in %q", spec)
+			}
+			arg = text[i+1 : i+end]
+			i += end + 1
+		}
+		if i >= len(text) {
+			return nil, fmt.Errorf("LogFormat %q ends in a bare %%", spec)
+		}
+
+		pattern := anyField
+		if strings.HasSuffix(expr.String(), `"`) {
+			pattern = quotedField
+		}
+
+		letter := text[i]
+		if letter == 'r' {
+			var p piece
+			p.field = -1
+			for j, name := range requestFields {
+				p.request[j] = f.addField(name, false)
+			}
+			f.pieces = append(f.pieces, p)
+			expr.WriteString(pattern)
+			continue
+		}
+
+		d, err := lookup(letter, arg)
+		if err != nil {
+			return nil, err
+		}
+		if d.pattern != "" {
+			pattern = d.pattern
+		}
+		f.pieces = append(f.pieces, piece{
+			field:   f.addField(d.name, d.numeric),
+			convert: d.convert,
+		})
+		expr.WriteString(pattern)
+	}
+	expr.WriteString("$")
+
+	var err error
+	if f.re, err = regexp.Compile(expr.String()); err != nil {
+		return nil, fmt.Errorf("LogFormat %q: %v", spec, err)
+	}
+	return f, nil
+}
+
+// lookup finds what a directive letter, and its {argument},
+// if any, will put in a log line.
+func lookup(letter byte, arg string) (directive, error) {
+	if arg != "" {
+		name := strings.ReplaceAll(strings.ToLower(arg), "-", "_")
+		switch letter {
+		case 'i':
+			if historical, ok := headerNames[name]; ok {
+				name = historical
+			}
+			return directive{name: name}, nil
+		case 'o':
+			return directive{name: "resp_" + name}, nil
+		case 'e':
+			return directive{name: "env_" + name}, nil
+		case 'n':
+			return directive{name: "note_" + name}, nil
+		case 'C':
+			return directive{name: "cookie_" + name}, nil
+		case 'a', 'p', 'P':
+			// client vs peer address, which port, pid vs tid
+			return directives[letter], nil
+		case 'T':
+			switch arg {
+			case "s":
+				return directives['T'], nil
+			case "ms":
+				return directive{name: "duration", numeric: true, convert: millisToMicros}, nil
+			case "us":
+				return directives['D'], nil
+			}
+		}
+		return directive{}, fmt.Errorf("unsupported LogFormat directive %%{%s}%c", arg, letter)
+	}
+	d, ok := directives[letter]
+	if !ok {
+		return directive{}, fmt.Errorf("unsupported LogFormat directive %%%c", letter)
+	}
+	return d, nil
+}
+
+// addField returns the index in f.Fields of a field named name,
+// adding it if this is the first directive that produces it.
+func (f *Format) addField(name string, numeric bool) int {
+	for i := range f.Fields {
+		if f.Fields[i].Name == name {
+			return i
+		}
+	}
+	f.Fields = append(f.Fields, Field{Name: name, Numeric: numeric})
+	return len(f.Fields) - 1
+}
+
+// ErrNoMatch is what Split returns for lines not in the Format.
+var ErrNoMatch = errors.New("line does not match log format")
+
+// Split breaks a log line into the values of f.Fields, in the same order.
+func (f *Format) Split(line string) ([]string, error) {
+	matches := f.re.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, ErrNoMatch
+	}
+	values := make([]string, len(f.Fields))
+	for i, p := range f.pieces {
+		value := matches[i+1]
+		if p.field < 0 {
+			if request := strings.Fields(value); len(request) > 2 {
+				for j := range p.request {
+					values[p.request[j]] = request[j]
+				}
+			}
+			continue
+		}
+		if p.convert != nil {
+			value = p.convert(value)
+		}
+		values[p.field] = value
+	}
+	return values, nil
+}
+
+// unescape interprets the backslash escapes that httpd allows in
+// a LogFormat string.
+func unescape(spec string) string {
+	var sb strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '\\' || i+1 == len(spec) {
+			sb.WriteByte(spec[i])
+			continue
+		}
+		i++
+		switch spec[i] {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		default:
+			sb.WriteByte(spec[i])
+		}
+	}
+	return sb.String()
+}
+
+// secondsToMicros makes %T durations comparable with %D durations.
+func secondsToMicros(seconds string) string {
+	return scale(seconds, 1000000)
+}
+
+// millisToMicros makes %{ms}T durations comparable with %D durations.
+func millisToMicros(millis string) string {
+	return scale(millis, 1000)
+}
+
+func scale(value string, factor int64) string {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return strconv.FormatInt(n*factor, 10)
+}
+
+// Presets are the LogFormat strings of formats that have well-known
+// names. "nginx" is nginx's default "combined" log_format, which has
+// a literal dash where httpd has %l.
+var Presets = map[string]string{
+	"common":         `%h %l %u %t \"%r\" %>s %b`,
+	"combined":       `%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-Agent}i\"`,
+	"vhost_combined": `%v:%p %h %l %u %t \"%r\" %>s %O \"%{Referer}i\" \"%{User-Agent}i\"`,
+	"nginx":          `%h - %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-Agent}i\"`,
+}
+
+// PresetNames lists the keys of Presets in alphabetical order.
+func PresetNames() []string {
+	return slices.Sorted(maps.Keys(Presets))
+}
@@ -0,0 +1,178 @@
+package logformat
+
+import (
+	"reflect"
+	"testing"
+)
+
+const combinedSpec = `%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-Agent}i\"`
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		wantFields []Field
+		wantErr    bool
+	}{
+		{
+			name: "combined",
+			spec: combinedSpec,
+			wantFields: []Field{
+				{Name: "ipaddr"},
+				{Name: "ident"},
+				{Name: "user"},
+				{Name: "timestamp"},
+				{Name: "method"},
+				{Name: "url"},
+				{Name: "version"},
+				{Name: "code", Numeric: true},
+				{Name: "size", Numeric: true},
+				{Name: "referrer"},
+				{Name: "useragent"},
+			},
+		},
+		{
+			name: "vhost, port and duration",
+			spec: `%v:%p %h %D`,
+			wantFields: []Field{
+				{Name: "vhost"},
+				{Name: "port", Numeric: true},
+				{Name: "ipaddr"},
+				{Name: "duration", Numeric: true},
+			},
+		},
+		{
+			name: "arbitrary header",
+			spec: `%h "%{X-Forwarded-For}i"`,
+			wantFields: []Field{
+				{Name: "ipaddr"},
+				{Name: "x_forwarded_for"},
+			},
+		},
+		{
+			name:    "unknown directive",
+			spec:    `%h %Z`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated argument",
+			spec:    `%h %{Referer`,
+			wantErr: true,
+		},
+		{
+			name:    "bare percent",
+			spec:    `%h %`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Compile(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(f.Fields, tt.wantFields) {
+				t.Errorf("Compile() fields = %v, want %v", f.Fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestFormat_Split(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "combined",
+			spec: combinedSpec,
+			line: `10.0.0.5 - bob [01/May/2024:14:35:10 +0000] "POST /login.php HTTP/1.1" 404 312 "-" "agent \"quoted\" here"`,
+			want: []string{
+				"10.0.0.5", "-", "bob", "[01/May/2024:14:35:10 +0000]",
+				"POST", "/login.php", "HTTP/1.1", "404", "312", "-",
+				`agent \"quoted\" here`,
+			},
+		},
+		{
+			name: "vhost and seconds",
+			spec: `%v:%p %h %T`,
+			line: `example.com:443 10.0.0.5 2`,
+			want: []string{"example.com", "443", "10.0.0.5", "2000000"},
+		},
+		{
+			name: "garbage request line",
+			spec: `%h "%r" %>s`,
+			line: `10.0.0.5 "\x16\x03\x01" 400`,
+			want: []string{"10.0.0.5", "", "", "", "400"},
+		},
+		{
+			name:    "wrong format",
+			spec:    combinedSpec,
+			line:    `10.0.0.5 - bob [01/May/2024:14:35:10 +0000] "GET / HTTP/1.1" 200 312`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Compile(tt.spec)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, err := f.Split(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Format.Split() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Format.Split() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresets(t *testing.T) {
+	tests := []struct {
+		preset string
+		line   string
+		want   map[string]string
+	}{
+		{
+			preset: "common",
+			line:   `10.0.0.5 - bob [01/May/2024:14:35:10 +0000] "GET /a HTTP/1.1" 200 -`,
+			want:   map[string]string{"ipaddr": "10.0.0.5", "ident": "-", "user": "bob", "size": "-"},
+		},
+		{
+			preset: "vhost_combined",
+			line:   `example.com:443 10.0.0.5 - - [01/May/2024:14:35:10 +0000] "GET /a HTTP/1.1" 200 312 "-" "curl/8"`,
+			want:   map[string]string{"vhost": "example.com", "port": "443", "useragent": "curl/8"},
+		},
+		{
+			preset: "nginx",
+			line:   `10.0.0.5 - - [01/May/2024:14:35:10 +0000] "" 400 0 "-" "Mozilla \x22quoted\x22"`,
+			want:   map[string]string{"code": "400", "method": "", "useragent": `Mozilla \x22quoted\x22`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			f, err := Compile(Presets[tt.preset])
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			values, err := f.Split(tt.line)
+			if err != nil {
+				t.Fatalf("Format.Split() error = %v", err)
+			}
+			for i := range f.Fields {
+				want, ok := tt.want[f.Fields[i].Name]
+				if ok && values[i] != want {
+					t.Errorf("field %s = %q, want %q", f.Fields[i].Name, values[i], want)
+				}
+			}
+		})
+	}
+}
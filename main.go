@@ -2,27 +2,44 @@ package main
 
 import (
 	"bufio"
+	"combined/logformat"
 	"combined/parser"
 	"combined/tree"
+	"combined/useragent"
+	"combined/vm"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
 	"os"
 	"regexp"
-	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func main() {
 
-	matching, matchingProgram, outputFields, wholeLineOut, rfc3339Timestamps, badLinesFileName, err := examineArguments()
+	opts, err := examineArguments()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "argument error: %v\n", err)
 		return
 	}
 
-	fopen, err := newFileOpener(badLinesFileName)
+	if opts.explain {
+		opts.matchTree.Print(os.Stdout)
+		fmt.Println()
+		return
+	}
+
+	if opts.dumpProgram {
+		opts.matchProgram.Dump(os.Stdout)
+		return
+	}
+
+	fopen, err := newFileOpener(opts.badLinesFileName, opts.follow)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "input file problem: %v\n", err)
 		return
@@ -31,10 +48,7 @@ func main() {
 	var cont bool
 
 	for cont, err = fopen.NextFile(); cont && err == nil; cont, err = fopen.NextFile() {
-		if err := scanAllines(
-			fopen.currentFile, fopen.badLinesFile,
-			matching, matchingProgram,
-			outputFields, wholeLineOut, rfc3339Timestamps); err != nil {
+		if err := scanAllines(fopen.currentReader, fopen.badLinesFile, opts); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 		}
 	}
@@ -43,6 +57,8 @@ func main() {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
 
+	opts.output.Finish()
+
 	err = fopen.Done()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "closing files: %v\n", err)
@@ -50,38 +66,42 @@ func main() {
 
 }
 
-// scanAllines calls a function (argument fn) on all lines
-// of linesIn argument one at a time. Can print some error messages
+// options holds everything that the command line asks for.
+type options struct {
+	matching         *matchSpec
+	matchTree        *tree.Node // optimized parse tree of matchProgram
+	matchProgram     *vm.Program
+	dumpProgram      bool // list matchProgram's instructions, don't read input
+	explain          bool // print matchTree, don't read input
+	output           emitter
+	badLinesFileName string
+	follow           bool
+	lineParser       func(string) (*parsedEntry, error)
+	jobs             int // goroutines parsing and matching lines
+}
+
+// scanAllines reads all lines of linesIn argument one at a time,
+// and outputs the ones that match. Can print some error messages
 // on os.Stderr. Control flow for line scanning.
-func scanAllines(linesIn *os.File, linesError *os.File, matching *matchSpec, matchProgram *tree.Node, outputFields []int, wholeLineOut, rfc3339Timestamps bool) error {
+func scanAllines(linesIn io.Reader, linesError *os.File, opts *options) error {
 
 	scanner := bufio.NewScanner(linesIn)
 	/* For longer lines:
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 	*/
 
+	if opts.jobs > 1 {
+		return scanInParallel(scanner, linesError, opts)
+	}
+
 	lineCounter := 0
 
 	for scanner.Scan() {
 		lineCounter++
 		line := scanner.Text()
-		if pe, err := combinedLogLineParser(line); err != nil {
-			if linesError != nil {
-				_, _ = fmt.Fprintf(linesError, "%s\n", line)
-			}
-			fmt.Fprintf(os.Stderr, "line %d: %v\n", lineCounter, err)
-		} else if pe != nil {
-			// pe points to a filled-in parsedEntry struct
-			if lineMatches(matching, matchProgram, pe) {
-				if wholeLineOut {
-					fmt.Printf("%s\n", line)
-					continue
-				}
-				performOutput(outputFields, pe, rfc3339Timestamps)
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "line %d: no error, also no parsed line\n", lineCounter)
-		}
+		pe, err := opts.lineParser(line)
+		matched := err == nil && pe != nil && lineMatches(opts.matching, opts.matchProgram, pe)
+		handleLine(lineCounter, line, pe, err, matched, linesError, opts)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -91,16 +111,37 @@ func scanAllines(linesIn *os.File, linesError *os.File, matching *matchSpec, mat
 	return nil
 }
 
+// handleLine outputs a parsed line if it matched, or complains
+// about a line that didn't parse.
+func handleLine(lineCounter int, line string, pe *parsedEntry, err error, matched bool, linesError *os.File, opts *options) {
+	if err != nil {
+		if linesError != nil {
+			_, _ = fmt.Fprintf(linesError, "%s\n", line)
+		}
+		fmt.Fprintf(os.Stderr, "line %d: %v\n", lineCounter, err)
+	} else if pe != nil {
+		// pe points to a filled-in parsedEntry struct
+		if matched {
+			opts.output.Emit(pe)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "line %d: no error, also no parsed line\n", lineCounter)
+	}
+}
+
 //	fopen, closefn, closeferr, err :=
 
 type fopenr struct {
-	currentFile  *os.File
-	badLinesFile *os.File
-	nargsIndex   int
+	currentFile   *os.File
+	currentReader io.Reader // currentFile's lines, decompressed if need be
+	closeReader   func() error
+	badLinesFile  *os.File
+	nargsIndex    int
+	follow        bool // keep reading the last file named, like tail -F
 }
 
 func (fop *fopenr) Done() error {
-	e1 := fop.currentFile.Close()
+	e1 := fop.closeCurrent()
 	if fop.badLinesFile != nil {
 		e2 := fop.badLinesFile.Close()
 		e1 = errors.Join(e1, e2)
@@ -113,25 +154,63 @@ func (fop *fopenr) Done() error {
 // Vacuous case: opens stdin, but only once.
 // Returns a bool (true means there's freshly opened file),
 // or false and an error. An iterator of sorts.
+// Compressed files get decompressed on the fly. In follow mode, the last
+// file named never reaches end-of-file. A file that won't open, or won't
+// decompress, gets an error message on stderr, and NextFile goes on to
+// the next file named.
 func (fop *fopenr) NextFile() (bool, error) {
-	if flag.NArg() > fop.nargsIndex {
-		fop.currentFile.Close()
-		var fin *os.File
-		var err error
-		if fin, err = os.Open(flag.Arg(fop.nargsIndex)); err != nil {
-			return false, err
+	for flag.NArg() > fop.nargsIndex {
+		fop.closeCurrent()
+		fin, err := os.Open(flag.Arg(fop.nargsIndex))
+		fop.nargsIndex++
+		if err != nil {
+			// one bad file shouldn't keep the rest from getting read
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			continue
 		}
 		fop.currentFile = fin
-		fop.nargsIndex++
+		if fop.follow && fop.nargsIndex == flag.NArg() {
+			fr := newFollowReader(fin)
+			fop.currentReader, fop.closeReader = fr, fr.Close
+			return true, nil
+		}
+		if fop.currentReader, fop.closeReader, err = decompressingReader(fin); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fin.Name(), err)
+			continue
+		}
 		return true, nil
-	} else if fop.currentFile == nil {
+	}
+	if flag.NArg() == 0 && fop.currentFile == nil {
 		fop.currentFile = os.Stdin
+		var err error
+		if fop.currentReader, fop.closeReader, err = decompressingReader(os.Stdin); err != nil {
+			return false, fmt.Errorf("stdin: %v", err)
+		}
 		return true, nil
 	}
 	return false, nil
 }
 
-func newFileOpener(badLinesFileName string) (*fopenr, error) {
+// closeCurrent cleans up after any decompression of the current
+// file, then closes it.
+func (fop *fopenr) closeCurrent() error {
+	var e1 error
+	if fop.closeReader != nil {
+		e1 = fop.closeReader()
+		fop.closeReader = nil
+	}
+	if fop.currentFile == nil {
+		return e1
+	}
+	e2 := fop.currentFile.Close()
+	if errors.Is(e2, os.ErrClosed) {
+		// a followReader closed it after log rotation
+		e2 = nil
+	}
+	return errors.Join(e1, e2)
+}
+
+func newFileOpener(badLinesFileName string, follow bool) (*fopenr, error) {
 	var ferr *os.File
 	var err error
 
@@ -146,17 +225,14 @@ func newFileOpener(badLinesFileName string) (*fopenr, error) {
 		currentFile:  nil,
 		badLinesFile: ferr,
 		nargsIndex:   0,
+		follow:       follow,
 	}
 
 	return fop, nil
 }
 
-var logLineTS = regexp.MustCompile(`^([^ ]+) - ([^ ]*) (\[[^]]+\]).*`)
-var logLineUR = regexp.MustCompile(`^([^ ]+) - ([^ ]*) (\[[^]]+\]) "([^"]*)".*`)
-var logLineCD = regexp.MustCompile(`^([^ ]+) - ([^ ]*) (\[[^]]+\]) "([^"]*)" (\d{1,}).*`)
-var logLineSZ = regexp.MustCompile(`^([^ ]+) - ([^ ]*) (\[[^]]+\]) "([^"]*)" (\d{1,}) (\d{1,}).*`)
-var logLineRF = regexp.MustCompile(`^([^ ]+) - ([^ ]*) (\[[^]]+\]) "([^"]*)" (\d{1,}) (\d{1,}) "([^"]*)".*$`)
-var logLineXX = regexp.MustCompile(`^([^ ]+) - ([^ ]*) (\[[^]]+\]) "([^"]*)" (\d{1,}) (\d{1,}) "([^"]*)" "([^"]*)"$`)
+// durationIndex is where combinedLogLineParser puts a %D duration
+var durationIndex = parser.FieldToIndex["duration"]
 
 // parsedEntry holds a combined format line broken into sub-strings. No
 // intra-field parsing or interpretation except for method/URL/HTTP version
@@ -165,7 +241,7 @@ type parsedEntry struct {
 	line   string   // original, entire log file line
 	fields []string // different fields in a slice
 	// [0]  IP address
-	// [1]  some garbage
+	// [1]  authenticated user, %u
 	// [2]  timestamp
 	// [3]  Method
 	// [4]  URL
@@ -174,72 +250,41 @@ type parsedEntry struct {
 	// [7]  count of bytes sent
 	// [8]  referrer
 	// [9]  User Agent
+	// [10] identd user, %l
+	when           time.Time // timestamp field, parsed on demand
+	whenErr        error
+	whenKnown      bool
+	ip             netip.Addr // ipaddr field, parsed on demand
+	ipErr          error
+	ipKnown        bool
+	derived        map[int]string // derived fields, computed on demand
+	parsedURL      *url.URL
+	params         url.Values
+	parsedReferrer *url.URL
+	uaClass        *useragent.Class
 }
 
-// combinedLogLineParser uses an elaborate regexp to parse
-// each line of text it's given into various fields, each of
-// which has some semantic content.
-func combinedLogLineParser(textIn string) (*parsedEntry, error) {
-	// a few user agents contain literal '\"' 2-character strings
-	text := strings.ReplaceAll(textIn, `\"`, "''")
-	matches := logLineXX.FindAllStringSubmatch(text, -1)
-	if len(matches) > 0 {
-		if len(matches[0]) > 8 {
-			// matches[0][1]  IP address
-			// matches[0][2]  some garbage
-			// matches[0][3]  timestamp
-			// matches[0][4]  Method URL HTTPversion
-			// matches[0][5]  HTTP status code
-			// matches[0][6]  count of bytes sent
-			// matches[0][7]  referrer
-			// matches[0][8]  User Agent
-			fields := strings.Fields(matches[0][4])
-			var method, url, version string
-			if len(fields) > 2 {
-				method = fields[0]
-				url = fields[1]
-				version = fields[2]
-			}
-
-			p := &parsedEntry{
-				line: textIn,
-				fields: []string{
-					matches[0][1],
-					matches[0][2],
-					matches[0][3],
-					method,
-					url,
-					version,
-					matches[0][5],
-					matches[0][6],
-					matches[0][7],
-					matches[0][8],
-				},
-			}
+// timestamp parses the timestamp field the first time a match
+// program asks for it, and hands back the same time.Time, or
+// parsing error, on later calls for the same line.
+func (pe *parsedEntry) timestamp() (time.Time, error) {
+	if !pe.whenKnown {
+		pe.when, pe.whenErr = time.Parse(`[02/Jan/2006:15:04:05 -0700]`, pe.fields[2])
+		pe.whenKnown = true
+	}
+	return pe.when, pe.whenErr
+}
 
-			return p, nil
-		}
-		// line matched the combined format regexp, but somehow
-		// did not have the required number of sub-matches. Weird.
-		return nil, errors.New("something very bad happened. WTF?")
-	} else {
-		if matches := logLineTS.FindAllStringSubmatch(text, -1); len(matches) > 0 {
-			fmt.Fprintf(os.Stderr, "matched IP address and Timestamp\n")
-		}
-		if matches := logLineUR.FindAllStringSubmatch(text, -1); len(matches) > 0 {
-			fmt.Fprintf(os.Stderr, "matched IP address and Timestamp and URL\n")
-		}
-		if matches := logLineCD.FindAllStringSubmatch(text, -1); len(matches) > 0 {
-			fmt.Fprintf(os.Stderr, "matched IP address and Timestamp and URL and Code\n")
-		}
-		if matches := logLineSZ.FindAllStringSubmatch(text, -1); len(matches) > 0 {
-			fmt.Fprintf(os.Stderr, "matched IP address and Timestamp and URL and Code and Size\n")
-		}
-		if matches := logLineRF.FindAllStringSubmatch(text, -1); len(matches) > 0 {
-			fmt.Fprintf(os.Stderr, "matched IP address and Timestamp and URL and Code and Size and Referer\n")
-		}
-		return nil, errors.New("no matches")
+// addr parses the ipaddr field the first time a match program
+// asks for it. IPv4-mapped IPv6 addresses come back as plain IPv4,
+// so that they fall in IPv4 network prefixes.
+func (pe *parsedEntry) addr() (netip.Addr, error) {
+	if !pe.ipKnown {
+		pe.ip, pe.ipErr = netip.ParseAddr(pe.fields[0])
+		pe.ip = pe.ip.Unmap()
+		pe.ipKnown = true
 	}
+	return pe.ip, pe.ipErr
 }
 
 type matchSpec struct {
@@ -249,34 +294,205 @@ type matchSpec struct {
 	matchRegexp *regexp.Regexp
 }
 
-func examineArguments() (*matchSpec, *tree.Node, []int, bool, bool, string, error) {
+func examineArguments() (*options, error) {
 	badLineFileName := flag.String("b", "", "unparseable lines file name")
 	outputFields := flag.String("f", "", "output field(s), comma separated")
 	matchExpression := flag.String("m", "", "match expression, field=value or field~regexp")
 	wholeLineOutput := flag.Bool("L", false, "output log file line on match, otherwise fields")
 	rfc3339Timestamps := flag.Bool("r", false, "output timestamps in RFC3339 format")
+	timeZone := flag.String("tz", "", "convert output timestamps to this time zone, like America/Denver")
+	timeLayout := flag.String("time-format", "", "output timestamp format: Go layout, rfc3339, epoch or epochms")
+	durationUnit := flag.String("duration-unit", "us", "output duration units: us, ms or s")
 	matchProgram := flag.String("e", "", "AND/OR/NOT boolean sentence for match")
+	dumpProgram := flag.Bool("dump-program", false, "list the instructions that -e compiles to, and exit")
+	explain := flag.Bool("explain", false, "print -e as optimized, and exit")
+	logFormat := flag.String("F", "", "httpd LogFormat string describing input lines")
+	follow := flag.Bool("follow", false, "keep reading last file as it grows, across log rotations")
+	countBy := flag.String("count-by", "", "count matching lines by field(s), comma separated")
+	top := flag.Int("top", 0, "with -count-by, output only the N largest counts")
+	histogram := flag.String("histogram", "", "count matching lines in time buckets of this width, like 5m")
+	splitBy := flag.String("split", "", "with -histogram, count separately by this field's values")
+	outputFormat := flag.String("o", "tsv", "output format for fields: tsv, json or csv")
+	delimiter := flag.String("delim", ",", "with -o csv, field delimiter character")
+	templateText := flag.String("template", "", "Go text/template for each matching line, like '{{.IPAddr}} {{.URL}}'")
+	site := flag.String("site", "", "this site's host name(s), comma separated, for the refexternal field")
+	uaRules := flag.String("ua-rules", "", "file of user agent rules, checked before built-in rules")
+	jobs := flag.Int("j", 1, "parse and match lines with this many goroutines")
+	formatName := flag.String("format", "combined", "named input line format: "+strings.Join(logformat.PresetNames(), ", "))
 
 	flag.Parse()
 	var err error
 
-	var me *matchSpec
+	opts := &options{
+		badLinesFileName: *badLineFileName,
+		follow:           *follow,
+		lineParser:       combinedLogLineParser,
+		jobs:             *jobs,
+	}
+	if opts.jobs < 1 {
+		return nil, fmt.Errorf("-j wants at least 1 goroutine, not %d", opts.jobs)
+	}
+
+	if *formatName != "combined" {
+		if *logFormat != "" {
+			return nil, errors.New("use only one of -F and -format")
+		}
+		spec, ok := logformat.Presets[*formatName]
+		if !ok {
+			return nil, fmt.Errorf("unknown -format %q", *formatName)
+		}
+		*logFormat = spec
+	}
+
+	// LogFormat fields have to exist before -m, -e or -f can name them
+	if *logFormat != "" {
+		f, err := logformat.Compile(*logFormat)
+		if err != nil {
+			return nil, err
+		}
+		opts.lineParser = formatLineParser(f)
+	}
+
+	addSiteHosts(*site)
+
+	if *uaRules != "" {
+		if err := loadUserAgentRules(*uaRules); err != nil {
+			return nil, err
+		}
+	}
+
 	if *matchExpression != "" {
-		me, err = createMatching(*matchExpression)
+		opts.matching, err = createMatching(*matchExpression)
 		if err != nil {
-			return nil, nil, nil, false, false, "", err
+			return nil, err
 		}
 	}
 
-	var ms *tree.Node
 	if *matchProgram != "" {
-		ms, err = createMatchProgram(*matchProgram)
+		opts.matchTree, opts.matchProgram, err = createMatchProgram(*matchProgram)
 		if err != nil {
-			return nil, nil, nil, false, false, "", err
+			return nil, err
 		}
 	}
+	if *dumpProgram {
+		if opts.matchProgram == nil {
+			return nil, errors.New("-dump-program needs an -e sentence")
+		}
+		opts.dumpProgram = true
+	}
+	if *explain {
+		if opts.matchProgram == nil {
+			return nil, errors.New("-explain needs an -e sentence")
+		}
+		opts.explain = true
+	}
+
+	modes := 0
+	for _, set := range []bool{*wholeLineOutput, *countBy != "", *histogram != "", *outputFormat != "tsv", *templateText != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, errors.New("use only one of -L, -count-by, -histogram, -o and -template")
+	}
+	if *follow && *countBy != "" {
+		// counts only come out at end of input, which never happens
+		return nil, errors.New("-count-by can't work with -follow")
+	}
+	if *follow && *histogram != "" {
+		// same for histogram buckets
+		return nil, errors.New("-histogram can't work with -follow")
+	}
 
-	return me, ms, createOutputIndexes(*outputFields), *wholeLineOutput, *rfc3339Timestamps, *badLineFileName, nil
+	fieldsCSV := *outputFields
+	if *countBy != "" {
+		fieldsCSV = *countBy
+	}
+	fields, err := createOutputIndexes(fieldsCSV)
+	if err != nil {
+		return nil, err
+	}
+
+	times, err := newTimeFormat(*rfc3339Timestamps, *timeZone, *timeLayout)
+	if err != nil {
+		return nil, err
+	}
+	if times.unit, err = parseDurationUnit(*durationUnit); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case *countBy != "":
+		opts.output = newCountEmitter(fields, *top)
+	case *histogram != "":
+		interval, err := time.ParseDuration(*histogram)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("-histogram wants a positive duration like 5m or 1h, not %q", *histogram)
+		}
+		splitField := -1
+		if *splitBy != "" {
+			n, ok := parser.LookupField(*splitBy)
+			if !ok {
+				return nil, fmt.Errorf("unknown -split field %q", *splitBy)
+			}
+			splitField = n
+		}
+		opts.output = newHistogramEmitter(interval, splitField, times)
+	case *wholeLineOutput:
+		opts.output = lineEmitter{}
+	case *templateText != "":
+		if opts.output, err = newTemplateEmitter(*templateText, times); err != nil {
+			return nil, err
+		}
+	case *outputFormat == "json":
+		opts.output = newJSONEmitter(fields, times)
+	case *outputFormat == "csv":
+		comma, err := csvDelimiter(*delimiter)
+		if err != nil {
+			return nil, err
+		}
+		opts.output = newCSVEmitter(fields, times, comma)
+	case *outputFormat != "tsv":
+		return nil, fmt.Errorf("unknown -o output format %q", *outputFormat)
+	default:
+		opts.output = &fieldsEmitter{
+			outputFields: fields,
+			times:        times,
+		}
+	}
+
+	return opts, nil
+}
+
+// loadUserAgentRules puts the rules in a file ahead of
+// the built-in user agent classification rules.
+func loadUserAgentRules(fileName string) error {
+	fin, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	rules, err := useragent.Parse(fin)
+	if err != nil {
+		return fmt.Errorf("-ua-rules %s: %v", fileName, err)
+	}
+	userAgentRules.Prepend(rules)
+	return nil
+}
+
+// csvDelimiter checks that a -delim value is a single character
+// that can separate CSV fields. `\t` means a tab, since that's
+// awkward to type in a shell.
+func csvDelimiter(delim string) (rune, error) {
+	if delim == `\t` {
+		return '\t', nil
+	}
+	r := []rune(delim)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' || r[0] == utf8.RuneError {
+		return 0, fmt.Errorf("-delim %q is not a usable CSV delimiter", delim)
+	}
+	return r[0], nil
 }
 
 // createMatching fills in a *matchSpec struct based on
@@ -291,7 +507,7 @@ func createMatching(matchExpression string) (*matchSpec, error) {
 	fields := strings.Split(matchExpression, "=")
 	if len(fields) == 2 {
 		// exact match desired
-		fieldIndex, ok := parser.FieldToIndex[fields[0]]
+		fieldIndex, ok := parser.LookupField(fields[0])
 		if ok {
 			pattern := strings.TrimSuffix(strings.TrimPrefix(fields[1], "/"), "/")
 			return &matchSpec{
@@ -306,7 +522,7 @@ func createMatching(matchExpression string) (*matchSpec, error) {
 		fields = strings.Split(matchExpression, "~")
 		if len(fields) == 2 {
 			// regular expression match desired
-			fieldIndex, ok := parser.FieldToIndex[fields[0]]
+			fieldIndex, ok := parser.LookupField(fields[0])
 			if ok {
 				pattern := strings.TrimPrefix(strings.TrimSuffix(fields[1], "/"), "/")
 				r, err := regexp.Compile(pattern)
@@ -327,7 +543,7 @@ func createMatching(matchExpression string) (*matchSpec, error) {
 
 // lineMatches decides whether a given line of input (broken
 // into field as a *parsedEntry) matches the desired criteria.
-func lineMatches(ms *matchSpec, mp *tree.Node, pe *parsedEntry) bool {
+func lineMatches(ms *matchSpec, mp *vm.Program, pe *parsedEntry) bool {
 	if ms == nil && mp == nil {
 		return true
 	}
@@ -336,50 +552,56 @@ func lineMatches(ms *matchSpec, mp *tree.Node, pe *parsedEntry) bool {
 		return Match(mp, pe)
 	case ms != nil:
 		if ms.exactValue != "" {
-			return ms.exactValue == pe.fields[ms.fieldIndex]
+			return ms.exactValue == pe.field(ms.fieldIndex)
 		}
-		return ms.matchRegexp.MatchString(pe.fields[ms.fieldIndex])
+		return ms.matchRegexp.MatchString(pe.field(ms.fieldIndex))
 	}
 	fmt.Printf("fall thru false\n")
 	return false
 }
 
-// performOutput
-func performOutput(outputFields []int, pe *parsedEntry, rfc3339Timestamps bool) {
+// performOutput writes the fields of pe that outputFields lists, tab
+// separated, with timestamps and durations formatted as times says.
+func performOutput(outputFields []int, pe *parsedEntry, times *timeFormat) {
 	spacer := ""
 	for i := range outputFields {
-		if rfc3339Timestamps && outputFields[i] == 2 {
-			ts, err := time.Parse(`[02/Jan/2006:15:04:05 +0000]`, pe.fields[outputFields[i]])
+		if outputFields[i] == 2 {
+			ts, err := times.field(pe)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "time parsing: %v\n", err)
 				continue
 			}
-			fmt.Printf("%s%s", spacer, ts.Format(time.RFC3339))
+			fmt.Printf("%s%s", spacer, ts)
 			spacer = "\t"
 			continue
 		}
-		fmt.Printf("%s%s", spacer, pe.fields[outputFields[i]])
+		if parser.DurationFields[parser.FieldNames[outputFields[i]]] {
+			fmt.Printf("%s%s", spacer, times.duration(pe.field(outputFields[i])))
+			spacer = "\t"
+			continue
+		}
+		fmt.Printf("%s%s", spacer, pe.field(outputFields[i]))
 		spacer = "\t"
 	}
 	fmt.Println()
 }
 
-func createOutputIndexes(outputFieldsCSV string) []int {
+// createOutputIndexes turns a comma-separated list of field names into
+// field indexes, in the same order, so output columns come out in the
+// order asked for. A field named twice is output twice.
+func createOutputIndexes(outputFieldsCSV string) ([]int, error) {
 	if outputFieldsCSV == "" {
-		return parser.AllFieldsIndexes
+		return parser.AllFieldsIndexes, nil
 	}
 
 	var indexes []int
 	fields := strings.Split(outputFieldsCSV, ",")
 	for i := range fields {
-		if n, ok := parser.FieldToIndex[fields[i]]; ok {
-			indexes = append(indexes, n)
-		} else {
-			// unknown field
-			fmt.Fprintf(os.Stderr, "ignoring unknown output field %q\n", fields[i])
-			continue
+		n, ok := parser.LookupField(strings.TrimSpace(fields[i]))
+		if !ok {
+			return nil, fmt.Errorf("unknown output field %q", fields[i])
 		}
+		indexes = append(indexes, n)
 	}
-	sort.Ints(indexes)
-	return indexes
+	return indexes, nil
 }
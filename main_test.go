@@ -0,0 +1,109 @@
+package main
+
+import (
+	"combined/parser"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withArgs makes the command line's non-flag arguments args,
+// for as long as the test runs.
+func withArgs(t *testing.T, args ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("combined", flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFopenr_NextFile_SkipsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"first.log":  []byte("one\n"),
+		"bad.gz":     {0x1f, 0x8b, 0x00, 0x00, 0x00},
+		"second.gz":  gzipped(t, "two\n"),
+		"third.log":  []byte("three\n"),
+		"fourth.bz2": bzip2Lines,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var args []string
+	for _, name := range []string{"first.log", "missing.log", "bad.gz", "second.gz", "third.log", "fourth.bz2"} {
+		args = append(args, filepath.Join(dir, name))
+	}
+	withArgs(t, args...)
+
+	fop, err := newFileOpener("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read strings.Builder
+	stderr := capture(t, &os.Stderr, func() {
+		for {
+			ok, err := fop.NextFile()
+			if err != nil {
+				t.Errorf("NextFile() error = %v", err)
+			}
+			if !ok {
+				break
+			}
+			if _, err := io.Copy(&read, fop.currentReader); err != nil {
+				t.Errorf("reading %s: %v", fop.currentFile.Name(), err)
+			}
+		}
+	})
+	if err := fop.Done(); err != nil {
+		t.Errorf("Done() error = %v", err)
+	}
+
+	if want := "one\ntwo\nthree\nline one\nline two\n"; read.String() != want {
+		t.Errorf("read %q, want %q", read.String(), want)
+	}
+	for _, name := range []string{"missing.log", "bad.gz"} {
+		if !strings.Contains(stderr, name) {
+			t.Errorf("no complaint about %s in %q", name, stderr)
+		}
+	}
+	if n := strings.Count(stderr, "\n"); n != 2 {
+		t.Errorf("%d complaints, want 2: %q", n, stderr)
+	}
+}
+
+func TestCreateOutputIndexes(t *testing.T) {
+	tests := []struct {
+		fields  string
+		want    []int
+		wantErr bool
+	}{
+		{fields: "url,ipaddr", want: []int{4, 0}},
+		{fields: "code,code", want: []int{6, 6}},
+		{fields: "ipaddr, url", want: []int{0, 4}},
+		{fields: " method ,\tcode", want: []int{3, 6}},
+		{fields: "garbage", want: []int{1}},
+		{fields: "", want: parser.AllFieldsIndexes},
+		{fields: "nonesuch", wantErr: true},
+		{fields: "url,nonesuch", wantErr: true},
+		{fields: "url,", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			got, err := createOutputIndexes(tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createOutputIndexes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("createOutputIndexes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
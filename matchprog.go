@@ -4,53 +4,29 @@ import (
 	"combined/lexer"
 	"combined/parser"
 	"combined/tree"
-	"fmt"
-	"os"
+	"combined/vm"
 )
 
-func createMatchProgram(str string) (*tree.Node, error) {
+// createMatchProgram parses a match sentence, optimizes the parse
+// tree, and compiles the optimized tree into a program for the vm
+// package to run. It hands back the optimized tree, for -explain.
+func createMatchProgram(str string) (*tree.Node, *vm.Program, error) {
 	lxr := lexer.Lex(str)
 	psr := parser.NewParser(lxr)
 
-	return psr.Parse()
-}
-
-// Match exists to cover up the use of a recursive function
-func Match(root *tree.Node, pe *parsedEntry) bool {
-	return eval(root, pe)
-}
-
-// eval recursively traverses a tree of *tree.Node structs.
-// Recursion bottoms out in the EXACT_MATCH and REGEX_MATCH
-// cases, which create the true/false values that AND/OR/NOT
-// nodes act on. Since the tree comes from parser, it's unlikely
-// to have many, if any, errors, so just print them to stderr.
-func eval(node *tree.Node, pe *parsedEntry) bool {
-	if node == nil {
-		fmt.Fprintf(os.Stderr, "reached nil node in error\n")
-		return false
+	root, err := psr.Parse()
+	if err != nil {
+		return nil, nil, err
 	}
-	switch node.Op {
-	case lexer.OR:
-		if leftVal := eval(node.Left, pe); leftVal {
-			// short circuit evaluation
-			return true
-		}
-		return eval(node.Right, pe)
-	case lexer.AND:
-		if leftVal := eval(node.Left, pe); !leftVal {
-			// short circuit evaluation
-			return false
-		}
-		return eval(node.Right, pe)
-	case lexer.NOT:
-		return !eval(node.Left, pe)
-	case lexer.EXACT_MATCH:
-		return node.ExactValue == pe.fields[node.FieldIndex]
-	case lexer.REGEX_MATCH:
-		return node.Pattern.MatchString(pe.fields[node.FieldIndex])
-	default:
-		fmt.Fprintf(os.Stderr, "reached node with Type %s in error\n", node.Op)
-		return false
+	root = tree.Optimize(root)
+	prog, err := vm.Compile(root)
+	if err != nil {
+		return nil, nil, err
 	}
+	return root, prog, nil
+}
+
+// Match runs a compiled match sentence against a log line
+func Match(prog *vm.Program, pe *parsedEntry) bool {
+	return prog.Run(pe)
 }
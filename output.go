@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"combined/parser"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// emitter does something with each log file entry that matches.
+// Some emitters write output right away, others accumulate entries
+// and only write output when Finish gets called, after the last line
+// of the last input file.
+type emitter interface {
+	Emit(pe *parsedEntry)
+	Finish()
+}
+
+// lineEmitter writes the entire original log file line
+type lineEmitter struct{}
+
+func (lineEmitter) Emit(pe *parsedEntry) {
+	fmt.Printf("%s\n", pe.line)
+}
+
+func (lineEmitter) Finish() {}
+
+// fieldsEmitter writes the chosen fields, tab separated
+type fieldsEmitter struct {
+	outputFields []int
+	times        *timeFormat
+}
+
+func (fe *fieldsEmitter) Emit(pe *parsedEntry) {
+	performOutput(fe.outputFields, pe, fe.times)
+}
+
+func (fe *fieldsEmitter) Finish() {}
+
+// countEmitter counts matching entries by the values of some key
+// fields, and writes the counts, largest first, when it finishes.
+// Like "combined -f ... | sort | uniq -c | sort -rn | head", but
+// without the pipeline.
+type countEmitter struct {
+	keyFields []int
+	top       int // 0 means write all counts
+	counts    map[string]int
+}
+
+func newCountEmitter(keyFields []int, top int) *countEmitter {
+	return &countEmitter{
+		keyFields: keyFields,
+		top:       top,
+		counts:    make(map[string]int),
+	}
+}
+
+func (ce *countEmitter) Emit(pe *parsedEntry) {
+	values := make([]string, len(ce.keyFields))
+	for i, n := range ce.keyFields {
+		values[i] = pe.field(n)
+	}
+	ce.counts[strings.Join(values, "\t")]++
+}
+
+// Finish writes counts in descending order, with keys
+// in ascending order to break ties.
+func (ce *countEmitter) Finish() {
+	keys := make([]string, 0, len(ce.counts))
+	for key := range ce.counts {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if c := cmp.Compare(ce.counts[b], ce.counts[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	if ce.top > 0 && len(keys) > ce.top {
+		keys = keys[:ce.top]
+	}
+	for _, key := range keys {
+		fmt.Printf("%d\t%s\n", ce.counts[key], key)
+	}
+}
+
+// histogramEmitter counts matching entries in fixed-width time
+// buckets, optionally split by the values of another field, and
+// writes one row per bucket when it finishes. Buckets with no
+// entries get a row too, so that gaps in traffic show up. Buckets
+// start on the clock of times' zone, or of UTC if times has no zone,
+// so 24h buckets start at midnight there.
+type histogramEmitter struct {
+	interval    time.Duration
+	splitField  int // -1 means don't split
+	times       *timeFormat
+	counts      map[int64]map[string]int
+	splitValues map[string]bool
+	first, last time.Time
+}
+
+func newHistogramEmitter(interval time.Duration, splitField int, times *timeFormat) *histogramEmitter {
+	return &histogramEmitter{
+		interval:    interval,
+		splitField:  splitField,
+		times:       times,
+		counts:      make(map[int64]map[string]int),
+		splitValues: make(map[string]bool),
+	}
+}
+
+func (he *histogramEmitter) Emit(pe *parsedEntry) {
+	t, err := pe.timestamp()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "time parsing: %v\n", err)
+		return
+	}
+	bucket := he.wallClock(t).Truncate(he.interval)
+	if he.first.IsZero() || bucket.Before(he.first) {
+		he.first = bucket
+	}
+	if bucket.After(he.last) {
+		he.last = bucket
+	}
+
+	var value string
+	if he.splitField >= 0 {
+		value = pe.field(he.splitField)
+	}
+	he.splitValues[value] = true
+
+	if he.counts[bucket.UnixNano()] == nil {
+		he.counts[bucket.UnixNano()] = make(map[string]int)
+	}
+	he.counts[bucket.UnixNano()][value]++
+}
+
+// wallClock is what a clock in the output time zone reads at t, as a
+// UTC time, so that truncating it lines up with the zone's hours and
+// days, and adding intervals to it steps over daylight saving changes.
+func (he *histogramEmitter) wallClock(t time.Time) time.Time {
+	t = t.In(he.zone())
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// zone is where histogram buckets start on the hour
+func (he *histogramEmitter) zone() *time.Location {
+	if he.times.zone != nil {
+		return he.times.zone
+	}
+	return time.UTC
+}
+
+// Finish writes a bucket's start time and count on each line. Split
+// histograms get a header line naming the split field's values, and
+// a count for each value on each line.
+func (he *histogramEmitter) Finish() {
+	if he.first.IsZero() {
+		return
+	}
+	values := slices.Sorted(maps.Keys(he.splitValues))
+	if he.splitField >= 0 {
+		fmt.Printf("time\t%s\n", strings.Join(values, "\t"))
+	}
+	for bucket := he.first; !bucket.After(he.last); bucket = bucket.Add(he.interval) {
+		start := time.Date(bucket.Year(), bucket.Month(), bucket.Day(), bucket.Hour(), bucket.Minute(), bucket.Second(), bucket.Nanosecond(), he.zone())
+		fmt.Print(he.times.format(start))
+		for _, value := range values {
+			fmt.Printf("\t%d", he.counts[bucket.UnixNano()][value])
+		}
+		fmt.Println()
+	}
+}
+
+// jsonEmitter writes a JSON object per matching entry, one per
+// line, keyed by field name. Numeric fields are JSON numbers, or
+// null when a log line has "-" or something else non-numeric, and
+// timestamps are RFC3339 strings, unless times has another layout.
+// Durations are numbers in times' units. A field listed twice only
+// gets one key, since JSON consumers handle duplicate keys badly.
+type jsonEmitter struct {
+	outputFields []int
+	times        *timeFormat
+	buf          bytes.Buffer
+	enc          *json.Encoder
+}
+
+func newJSONEmitter(outputFields []int, times *timeFormat) *jsonEmitter {
+	je := &jsonEmitter{times: times}
+	seen := make(map[int]bool)
+	for _, n := range outputFields {
+		if !seen[n] {
+			je.outputFields = append(je.outputFields, n)
+			seen[n] = true
+		}
+	}
+	je.enc = json.NewEncoder(&je.buf)
+	je.enc.SetEscapeHTML(false)
+	return je
+}
+
+func (je *jsonEmitter) Emit(pe *parsedEntry) {
+	je.buf.Reset()
+	je.buf.WriteByte('{')
+	for i, n := range je.outputFields {
+		if i > 0 {
+			je.buf.WriteByte(',')
+		}
+		name := parser.FieldNames[n]
+		je.enc.Encode(name)
+		je.trimNewline()
+		je.buf.WriteByte(':')
+		je.encodeValue(name, pe.field(n), pe)
+	}
+	je.buf.WriteString("}\n")
+	os.Stdout.Write(je.buf.Bytes())
+}
+
+// encodeValue puts a field's value in je.buf as the JSON type
+// that suits the field.
+func (je *jsonEmitter) encodeValue(name, value string, pe *parsedEntry) {
+	switch {
+	case parser.DurationFields[name]:
+		value = je.times.duration(value)
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			je.buf.WriteString(value)
+		} else {
+			je.buf.WriteString("null")
+		}
+		return
+	case parser.NumericFields[name]:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			je.buf.WriteString(strconv.FormatInt(n, 10))
+		} else {
+			je.buf.WriteString("null")
+		}
+		return
+	case parser.TimeFields[name]:
+		if t, err := pe.timestamp(); err == nil {
+			value = je.times.format(t)
+			if je.times.numeric() {
+				je.buf.WriteString(value)
+				return
+			}
+		}
+	}
+	je.enc.Encode(value)
+	je.trimNewline()
+}
+
+// trimNewline removes the newline that json.Encoder
+// puts after every value it encodes.
+func (je *jsonEmitter) trimNewline() {
+	je.buf.Truncate(je.buf.Len() - 1)
+}
+
+func (je *jsonEmitter) Finish() {}
+
+// csvEmitter writes RFC 4180 CSV: a header line of field
+// names, then a record per matching entry.
+type csvEmitter struct {
+	outputFields []int
+	times        *timeFormat
+	w            *csv.Writer
+	wroteHeader  bool
+}
+
+func newCSVEmitter(outputFields []int, times *timeFormat, delimiter rune) *csvEmitter {
+	ce := &csvEmitter{
+		outputFields: outputFields,
+		times:        times,
+		w:            csv.NewWriter(os.Stdout),
+	}
+	ce.w.Comma = delimiter
+	return ce
+}
+
+func (ce *csvEmitter) header() {
+	if ce.wroteHeader {
+		return
+	}
+	names := make([]string, len(ce.outputFields))
+	for i, n := range ce.outputFields {
+		names[i] = parser.FieldNames[n]
+	}
+	ce.w.Write(names)
+	ce.wroteHeader = true
+}
+
+func (ce *csvEmitter) Emit(pe *parsedEntry) {
+	ce.header()
+	record := make([]string, len(ce.outputFields))
+	for i, n := range ce.outputFields {
+		record[i] = pe.field(n)
+		if n == 2 {
+			if ts, err := ce.times.field(pe); err == nil {
+				record[i] = ts
+			}
+		}
+		if parser.DurationFields[parser.FieldNames[n]] {
+			record[i] = ce.times.duration(record[i])
+		}
+	}
+	ce.w.Write(record)
+	// flush every record, so -follow output doesn't lag
+	ce.w.Flush()
+}
+
+// Finish writes the header line, even if no entries matched.
+func (ce *csvEmitter) Finish() {
+	ce.header()
+	ce.w.Flush()
+}
+
+// templateEmitter writes each matching entry by executing a Go
+// text/template, with the *parsedEntry as the template's data.
+type templateEmitter struct {
+	tmpl  *template.Template
+	times *timeFormat
+	buf   bytes.Buffer
+}
+
+// templateEntry gives templates a Timestamp method
+// that converts to the -tz time zone.
+type templateEntry struct {
+	*parsedEntry
+	times *timeFormat
+}
+
+func (te templateEntry) Timestamp() time.Time {
+	return te.times.convert(te.parsedEntry.Timestamp())
+}
+
+// templateFuncs are helper functions, beyond text/template's own,
+// that -template output can use.
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"urldecode": func(s string) string {
+		if decoded, err := url.QueryUnescape(s); err == nil {
+			return decoded
+		}
+		return s
+	},
+	// truncate has its length first, so that it works in
+	// pipelines like {{.UserAgent | truncate 20}}
+	"truncate": func(n int, s string) string {
+		if r := []rune(s); len(r) > n {
+			return string(r[:n])
+		}
+		return s
+	},
+}
+
+func newTemplateEmitter(text string, times *timeFormat) (*templateEmitter, error) {
+	tmpl, err := template.New("-template").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &templateEmitter{tmpl: tmpl, times: times}, nil
+}
+
+// Emit writes a newline after each execution of the template.
+func (te *templateEmitter) Emit(pe *parsedEntry) {
+	te.buf.Reset()
+	if err := te.tmpl.Execute(&te.buf, templateEntry{pe, te.times}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+	te.buf.WriteByte('\n')
+	os.Stdout.Write(te.buf.Bytes())
+}
+
+func (te *templateEmitter) Finish() {}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"combined/parser"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout runs f, and hands back what it wrote on os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+// capture runs f with *file replaced by a pipe, and
+// hands back what f wrote on the pipe.
+func capture(t *testing.T, file **os.File, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *file
+	*file = w
+	defer func() { *file = saved }()
+
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// testEntry makes a *parsedEntry out of combined format fields,
+// as combinedLogLineParser would.
+func testEntry(fields ...string) *parsedEntry {
+	return &parsedEntry{fields: fields}
+}
+
+func TestJSONEmitter(t *testing.T) {
+	utc := &timeFormat{layout: time.RFC3339}
+	tests := []struct {
+		name   string
+		fields []string
+		times  *timeFormat
+		output []int
+		want   string
+	}{
+		{
+			name:   "numeric code and size",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "2326", "-", "curl", "-"},
+			times:  utc,
+			output: []int{3, 6, 7},
+			want:   `{"method":"GET","code":200,"size":2326}`,
+		},
+		{
+			name:   "field listed twice is one key",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "2326", "-", "curl", "-"},
+			times:  utc,
+			output: []int{6, 3, 6},
+			want:   `{"code":200,"method":"GET"}`,
+		},
+		{
+			name:   "dash is null",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "304", "-", "-", "curl", "-"},
+			times:  utc,
+			output: []int{7, 8},
+			want:   `{"size":null,"referrer":"-"}`,
+		},
+		{
+			name:   "escaped user agent",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "0", "-", "Bad \"Agent\"\t<é>", "-"},
+			times:  utc,
+			output: []int{9},
+			want:   `{"useragent":"Bad \"Agent\"\t<é>"}`,
+		},
+		{
+			name:   "RFC3339 timestamp",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 -0700]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-"},
+			times:  utc,
+			output: []int{2},
+			want:   `{"timestamp":"2023-10-10T13:55:36-07:00"}`,
+		},
+		{
+			name:   "epoch timestamp",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-"},
+			times:  &timeFormat{layout: "epoch"},
+			output: []int{2},
+			want:   `{"timestamp":1696946136}`,
+		},
+		{
+			name:   "epochms timestamp",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-"},
+			times:  &timeFormat{layout: "epochms"},
+			output: []int{2},
+			want:   `{"timestamp":1696946136000}`,
+		},
+		{
+			name:   "unparseable timestamp stays a string",
+			fields: []string{"10.0.0.1", "-", "[yesterday]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-"},
+			times:  &timeFormat{layout: "epoch"},
+			output: []int{2},
+			want:   `{"timestamp":"[yesterday]"}`,
+		},
+		{
+			name:   "duration in milliseconds",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-", "", "", "", "", "", "", "", "", "", "", "", "", "1500"},
+			times:  &timeFormat{layout: time.RFC3339, unit: time.Millisecond},
+			output: []int{durationIndex},
+			want:   `{"duration":1.5}`,
+		},
+		{
+			name:   "missing duration is null",
+			fields: []string{"10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-"},
+			times:  utc,
+			output: []int{durationIndex},
+			want:   `{"duration":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			je := newJSONEmitter(tt.output, tt.times)
+			got := captureStdout(t, func() {
+				je.Emit(testEntry(tt.fields...))
+				je.Finish()
+			})
+			if got != tt.want+"\n" {
+				t.Errorf("Emit() wrote %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVEmitter(t *testing.T) {
+	asIs := &timeFormat{asIs: true}
+	entry := testEntry("10.0.0.1", "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/a,b", "HTTP/1.1", "200", "15", "-", `say "hi"`, "-")
+	tests := []struct {
+		name    string
+		delim   rune
+		entries []*parsedEntry
+		want    string
+	}{
+		{
+			name:    "commas and quotes get quoted",
+			delim:   ',',
+			entries: []*parsedEntry{entry},
+			want:    "code,url,useragent\n200,\"/a,b\",\"say \"\"hi\"\"\"\n",
+		},
+		{
+			name:    "tab delimiter",
+			delim:   '\t',
+			entries: []*parsedEntry{entry},
+			want:    "code\turl\tuseragent\n200\t/a,b\t\"say \"\"hi\"\"\"\n",
+		},
+		{
+			name:    "semicolon delimiter",
+			delim:   ';',
+			entries: []*parsedEntry{entry},
+			want:    "code;url;useragent\n200;/a,b;\"say \"\"hi\"\"\"\n",
+		},
+		{
+			name:  "header without matches",
+			delim: ',',
+			want:  "code,url,useragent\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := captureStdout(t, func() {
+				// the csv.Writer holds on to os.Stdout
+				ce := newCSVEmitter([]int{6, 4, 9}, asIs, tt.delim)
+				for _, pe := range tt.entries {
+					ce.Emit(pe)
+				}
+				ce.Finish()
+			})
+			if got != tt.want {
+				t.Errorf("CSV output is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVDelimiter(t *testing.T) {
+	tests := []struct {
+		delim   string
+		want    rune
+		wantErr bool
+	}{
+		{delim: ",", want: ','},
+		{delim: ";", want: ';'},
+		{delim: `\t`, want: '\t'},
+		{delim: "\t", want: '\t'},
+		{delim: "|", want: '|'},
+		{delim: "", wantErr: true},
+		{delim: ",,", wantErr: true},
+		{delim: `"`, wantErr: true},
+		{delim: "\n", wantErr: true},
+		{delim: "\r", wantErr: true},
+		{delim: "\xff", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := csvDelimiter(tt.delim)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("csvDelimiter(%q) error = %v, wantErr %v", tt.delim, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("csvDelimiter(%q) = %q, want %q", tt.delim, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramEmitter(t *testing.T) {
+	entries := []*parsedEntry{
+		testEntry("10.0.0.1", "-", "[10/Oct/2023:05:55:36 +0000]"),
+		testEntry("10.0.0.1", "-", "[10/Oct/2023:07:55:36 +0000]"),
+		testEntry("10.0.0.1", "-", "[12/Oct/2023:13:55:36 +0000]"),
+	}
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{
+			zone: "",
+			want: "2023-10-10T00:00:00Z\t2\n2023-10-11T00:00:00Z\t0\n2023-10-12T00:00:00Z\t1\n",
+		},
+		{
+			// buckets start at Denver midnight, not 18:00
+			zone: "America/Denver",
+			want: "2023-10-09T00:00:00-06:00\t1\n2023-10-10T00:00:00-06:00\t1\n2023-10-11T00:00:00-06:00\t0\n2023-10-12T00:00:00-06:00\t1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			times, err := newTimeFormat(false, tt.zone, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			he := newHistogramEmitter(24*time.Hour, -1, times)
+			got := captureStdout(t, func() {
+				for _, pe := range entries {
+					he.Emit(pe)
+				}
+				he.Finish()
+			})
+			if got != tt.want {
+				t.Errorf("histogram is\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountEmitter(t *testing.T) {
+	var entries []*parsedEntry
+	for _, ipCode := range [][2]string{
+		{"10.0.0.2", "404"}, {"10.0.0.1", "200"}, {"10.0.0.2", "200"},
+		{"10.0.0.1", "200"}, {"10.0.0.3", "500"}, {"10.0.0.2", "404"},
+		{"10.0.0.1", "200"},
+	} {
+		entries = append(entries, testEntry(ipCode[0], "-", "[10/Oct/2023:13:55:36 +0000]", "GET", "/", "HTTP/1.1", ipCode[1], "0", "-", "curl", "-"))
+	}
+	tests := []struct {
+		name      string
+		keyFields []int
+		top       int
+		want      string
+	}{
+		{
+			name:      "largest count first, ties by key",
+			keyFields: []int{0},
+			want:      "3\t10.0.0.1\n3\t10.0.0.2\n1\t10.0.0.3\n",
+		},
+		{
+			name:      "two key fields",
+			keyFields: []int{0, 6},
+			want:      "3\t10.0.0.1\t200\n2\t10.0.0.2\t404\n1\t10.0.0.2\t200\n1\t10.0.0.3\t500\n",
+		},
+		{
+			name:      "top",
+			keyFields: []int{6},
+			top:       2,
+			want:      "4\t200\n2\t404\n",
+		},
+		{
+			name:      "top more than there are",
+			keyFields: []int{6},
+			top:       10,
+			want:      "4\t200\n2\t404\n1\t500\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := newCountEmitter(tt.keyFields, tt.top)
+			got := captureStdout(t, func() {
+				for _, pe := range entries {
+					ce.Emit(pe)
+				}
+				ce.Finish()
+			})
+			if got != tt.want {
+				t.Errorf("counts are\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateEmitter(t *testing.T) {
+	lines := []string{
+		`10.0.0.5 - - [10/Oct/2023:13:55:36 -0700] "GET /a%20b?q=caf%C3%A9 HTTP/1.1" 200 15 "-" "Mozilla/5.0 (X11; Linux x86_64)" 1500`,
+		`10.0.0.6 - - [10/Oct/2023:13:55:37 -0700] "GET /gone HTTP/1.1" 404 0 "-" "curl/8.0"`,
+	}
+	var entries []*parsedEntry
+	for _, line := range lines {
+		pe, err := combinedLogLineParser(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, pe)
+	}
+	junk := testEntry("10.0.0.7", "-", "[yesterday]", "GET", "/", "HTTP/1.1", "-", "-", "-", "-", "-")
+
+	tests := []struct {
+		name     string
+		text     string
+		zone     string
+		want     string
+		wantErrs int
+	}{
+		{
+			name: "typed accessors",
+			text: "{{.Code}} {{.Size}} {{.Duration}} {{.IPAddr}}",
+			want: "200 15 1.5ms 10.0.0.5\n404 0 0s 10.0.0.6\n0 0 0s 10.0.0.7\n",
+		},
+		{
+			name: "lower, truncate in a pipeline, urldecode",
+			text: "{{.UserAgent | truncate 7 | lower}} {{.URL | urldecode}}",
+			want: "mozilla /a b?q=café\ncurl/8. /gone\n- /\n",
+		},
+		{
+			name: "timestamp as logged",
+			text: `{{.Timestamp.Format "15:04:05 -0700"}}`,
+			want: "13:55:36 -0700\n13:55:37 -0700\n00:00:00 +0000\n",
+		},
+		{
+			name: "timestamp in -tz zone",
+			text: `{{.Timestamp.Format "15:04:05 MST"}}`,
+			zone: "UTC",
+			want: "20:55:36 UTC\n20:55:37 UTC\n00:00:00 UTC\n",
+		},
+		{
+			name:     "execution error skips the line",
+			text:     `{{if eq .Code 404}}{{.Nonesuch}}{{end}}{{.URL}}`,
+			want:     "/a%20b?q=caf%C3%A9\n/\n",
+			wantErrs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			times, err := newTimeFormat(false, tt.zone, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			te, err := newTemplateEmitter(tt.text, times)
+			if err != nil {
+				t.Fatalf("newTemplateEmitter() error = %v", err)
+			}
+			var got string
+			stderr := capture(t, &os.Stderr, func() {
+				got = captureStdout(t, func() {
+					for _, pe := range append(entries, junk) {
+						te.Emit(pe)
+					}
+					te.Finish()
+				})
+			})
+			if got != tt.want {
+				t.Errorf("template output is\n%s\nwant\n%s", got, tt.want)
+			}
+			if n := strings.Count(stderr, "\n"); n != tt.wantErrs {
+				t.Errorf("%d errors, want %d: %q", n, tt.wantErrs, stderr)
+			}
+		})
+	}
+
+	if _, err := newTemplateEmitter("{{.Code", &timeFormat{}); err == nil {
+		t.Errorf("newTemplateEmitter() of a bad template did not fail")
+	}
+}
+
+func TestHistogramEmitter_Split(t *testing.T) {
+	var entries []*parsedEntry
+	for _, timeCode := range [][2]string{
+		{"13:05", "200"}, {"13:10", "404"}, {"13:20", "200"}, {"15:00", "404"}, {"15:30", "-"},
+	} {
+		entries = append(entries, testEntry("10.0.0.1", "-", "[10/Oct/2023:"+timeCode[0]+":00 +0000]", "GET", "/", "HTTP/1.1", timeCode[1], "0", "-", "curl", "-"))
+	}
+	class := parser.FieldToIndex["class"]
+	times, _ := newTimeFormat(false, "", "")
+	he := newHistogramEmitter(time.Hour, class, times)
+	got := captureStdout(t, func() {
+		for _, pe := range entries {
+			he.Emit(pe)
+		}
+		he.Finish()
+	})
+	// a code of "-" has no class, so it counts in the empty column
+	want := "time\t\t2xx\t4xx\n" +
+		"2023-10-10T13:00:00Z\t0\t2\t1\n" +
+		"2023-10-10T14:00:00Z\t0\t0\t0\n" +
+		"2023-10-10T15:00:00Z\t1\t0\t1\n"
+	if got != want {
+		t.Errorf("histogram is\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// batchSize is how many lines a -j worker goroutine
+// parses and matches at a time.
+const batchSize = 1024
+
+// lineBatch holds consecutive lines of input, and what a
+// worker goroutine found out about each of them.
+type lineBatch struct {
+	first   int // line number of lines[0]
+	lines   []string
+	entries []*parsedEntry
+	errs    []error
+	matched []bool
+	done    chan struct{} // closed when a worker finishes the batch
+}
+
+// work parses and matches every line in b.
+func (b *lineBatch) work(opts *options) {
+	b.entries = make([]*parsedEntry, len(b.lines))
+	b.errs = make([]error, len(b.lines))
+	b.matched = make([]bool, len(b.lines))
+	for i, line := range b.lines {
+		pe, err := opts.lineParser(line)
+		b.entries[i], b.errs[i] = pe, err
+		b.matched[i] = err == nil && pe != nil && lineMatches(opts.matching, opts.matchProgram, pe)
+	}
+	close(b.done)
+}
+
+// scanInParallel does what scanAllines does, but with opts.jobs
+// goroutines parsing and matching batches of lines. Batches go to
+// the workers and, in input order, to this goroutine, which waits
+// for each batch to get finished, then outputs its matching lines.
+// So output comes out in input order, emitters only get used from
+// one goroutine, and at most a few batches per worker are in memory.
+func scanInParallel(scanner *bufio.Scanner, linesError *os.File, opts *options) error {
+	size := batchSize
+	if opts.follow {
+		// don't sit on lines waiting for a full batch to show up
+		size = 1
+	}
+
+	// settle which fields are derived before workers look
+	deriverFor(0)
+
+	work := make(chan *lineBatch)
+	ordered := make(chan *lineBatch, 2*opts.jobs)
+	for i := 0; i < opts.jobs; i++ {
+		go func() {
+			for b := range work {
+				b.work(opts)
+			}
+		}()
+	}
+
+	lineCounter := 0
+	var scanErr error
+	go func() {
+		defer close(ordered)
+		defer close(work)
+		b := &lineBatch{first: 1, done: make(chan struct{})}
+		for scanner.Scan() {
+			lineCounter++
+			b.lines = append(b.lines, scanner.Text())
+			if len(b.lines) == size {
+				ordered <- b
+				work <- b
+				b = &lineBatch{first: lineCounter + 1, done: make(chan struct{})}
+			}
+		}
+		if len(b.lines) > 0 {
+			ordered <- b
+			work <- b
+		}
+		scanErr = scanner.Err()
+	}()
+
+	for b := range ordered {
+		<-b.done
+		for i, line := range b.lines {
+			handleLine(b.first+i, line, b.entries[i], b.errs[i], b.matched[i], linesError, opts)
+		}
+	}
+
+	if scanErr != nil {
+		return fmt.Errorf("problem line %d: %v", lineCounter, scanErr)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingEmitter remembers the url field of each entry it gets
+type recordingEmitter struct {
+	urls []string
+}
+
+func (re *recordingEmitter) Emit(pe *parsedEntry) { re.urls = append(re.urls, pe.URL()) }
+func (re *recordingEmitter) Finish()              {}
+
+func TestScanInParallel_SameAsSerial(t *testing.T) {
+	// bad lines on both sides of a batch boundary, and in the middle
+	bad := map[int]bool{batchSize: true, batchSize + 1: true, 1500: true, 3 * batchSize: true}
+	var input strings.Builder
+	for n := 1; n <= 3*batchSize+100; n++ {
+		if bad[n] {
+			fmt.Fprintf(&input, "not a log line %d\n", n)
+			continue
+		}
+		code := 200
+		if n%7 == 0 {
+			code = 404
+		}
+		fmt.Fprintf(&input, "10.0.0.5 - - [10/Oct/2023:13:55:36 +0000] \"GET /%d HTTP/1.1\" %d 15 \"-\" \"curl\"\n", n, code)
+	}
+
+	_, program, err := createMatchProgram("code = /200/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(jobs int) ([]string, string) {
+		re := &recordingEmitter{}
+		opts := &options{
+			matchProgram: program,
+			output:       re,
+			lineParser:   combinedLogLineParser,
+			jobs:         jobs,
+		}
+		stderr := capture(t, &os.Stderr, func() {
+			if err := scanAllines(strings.NewReader(input.String()), nil, opts); err != nil {
+				t.Errorf("scanAllines() with %d jobs: %v", jobs, err)
+			}
+		})
+		return re.urls, stderr
+	}
+
+	serialURLs, serialErrs := run(1)
+	if len(serialURLs) == 0 || strings.Count(serialErrs, "\n") != len(bad) {
+		t.Fatalf("serial scan emitted %d lines, complained:\n%s", len(serialURLs), serialErrs)
+	}
+	for n := range bad {
+		if !strings.Contains(serialErrs, fmt.Sprintf("line %d: ", n)) {
+			t.Errorf("serial scan didn't complain about line %d", n)
+		}
+	}
+
+	for _, jobs := range []int{2, 4} {
+		urls, errs := run(jobs)
+		if !reflect.DeepEqual(urls, serialURLs) {
+			t.Errorf("%d jobs emitted %d lines, serial scan emitted %d, or in a different order", jobs, len(urls), len(serialURLs))
+		}
+		if errs != serialErrs {
+			t.Errorf("%d jobs complained:\n%s\nserial scan complained:\n%s", jobs, errs, serialErrs)
+		}
+	}
+}
@@ -1,15 +1,141 @@
 package parser
 
+import "strings"
+
 var AllFieldsIndexes = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
 var FieldToIndex = map[string]int{
-	"ipaddr":    0,
-	"garbage":   1,
-	"timestamp": 2,
-	"method":    3,
-	"url":       4,
-	"version":   5,
-	"code":      6,
-	"size":      7,
-	"referrer":  8,
-	"useragent": 9,
+	"ipaddr":      0,
+	"user":        1,
+	"garbage":     1, // deprecated, old name of "user"
+	"timestamp":   2,
+	"method":      3,
+	"url":         4,
+	"version":     5,
+	"code":        6,
+	"size":        7,
+	"referrer":    8,
+	"useragent":   9,
+	"ident":       10,
+	"path":        11,
+	"query":       12,
+	"ext":         13,
+	"refhost":     14,
+	"refpath":     15,
+	"refscheme":   16,
+	"refexternal": 17,
+	"browser":     18,
+	"os":          19,
+	"device":      20,
+	"isbot":       21,
+	"class":       22,
+	"duration":    23,
+}
+
+// FieldNames is the inverse of FieldToIndex, less any aliases
+var FieldNames = []string{
+	"ipaddr",
+	"user",
+	"timestamp",
+	"method",
+	"url",
+	"version",
+	"code",
+	"size",
+	"referrer",
+	"useragent",
+	"ident",
+	// derived from other fields, not in log lines themselves
+	"path",
+	"query",
+	"ext",
+	"refhost",
+	"refpath",
+	"refscheme",
+	"refexternal",
+	"browser",
+	"os",
+	"device",
+	"isbot",
+	"class",
+	"duration",
+}
+
+// FieldFamilies names parameterized fields, like param[utm_source],
+// that LookupField registers the first time something refers to
+// one of them.
+var FieldFamilies = map[string]bool{
+	"param": true,
+}
+
+// RegisterField makes a field name usable in match expressions and
+// output field lists, returning its index. The "combined" format
+// field names are always registered, and keep their indexes, so
+// registering one of those just returns its index.
+func RegisterField(name string) int {
+	if n, ok := FieldToIndex[name]; ok {
+		return n
+	}
+	FieldNames = append(FieldNames, name)
+	FieldToIndex[name] = len(FieldNames) - 1
+	return len(FieldNames) - 1
+}
+
+// Predicates are keywords that stand alone in a sentence, without a
+// match-op or pattern. Each is true for some classes of HTTP status
+// code, as the "class" field has them.
+var Predicates = map[string][]string{
+	"success":     {"2xx"},
+	"redirect":    {"3xx"},
+	"error":       {"4xx", "5xx"},
+	"clienterror": {"4xx"},
+	"servererror": {"5xx"},
+}
+
+// LookupField finds the index of a field name, registering
+// members of a FieldFamilies family as needed.
+func LookupField(name string) (int, bool) {
+	if n, ok := FieldToIndex[name]; ok {
+		return n, true
+	}
+	if family, _, ok := SplitFamily(name); ok && FieldFamilies[family] {
+		return RegisterField(name), true
+	}
+	return 0, false
+}
+
+// SplitFamily breaks a parameterized field name like "param[utm_source]"
+// into its family, "param", and its argument, "utm_source".
+func SplitFamily(name string) (family, arg string, ok bool) {
+	open := strings.IndexByte(name, '[')
+	if open < 1 || !strings.HasSuffix(name, "]") || len(name) < open+3 {
+		return "", "", false
+	}
+	return name[:open], name[open+1 : len(name)-1], true
+}
+
+// NumericFields names the fields that hold integers, and so
+// can appear on the left of the <, <=, >, >= and != operators.
+var NumericFields = map[string]bool{
+	"code":     true,
+	"size":     true,
+	"duration": true,
+}
+
+// DurationFields names the numeric fields that hold a time taken,
+// in microseconds. They compare against Go duration literals like
+// 500ms or 2s, as well as plain integer microseconds.
+var DurationFields = map[string]bool{
+	"duration": true,
+}
+
+// TimeFields names the fields that hold a timestamp, which the
+// ordering operators and "in" compare as times, not strings.
+var TimeFields = map[string]bool{
+	"timestamp": true,
+}
+
+// AddrFields names the fields that hold an IPv4 or IPv6 address,
+// which the @ operator checks for membership in network prefixes.
+var AddrFields = map[string]bool{
+	"ipaddr": true,
 }
@@ -1,20 +1,27 @@
 package parser
 
 import (
+	"bufio"
 	"combined/lexer"
 	"combined/tree"
 	"errors"
 	"fmt"
+	"net/netip"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 /*
 expr     -> term { OR term }
 term     -> factor { AND factor }
-factor   -> '(' expr ')' | NOT factor | boolean
-boolean  -> FIELD match-op PATTERN
-match-op -> '='|'~'
+factor   -> '(' expr ')' | NOT factor | boolean | predicate
+boolean  -> FIELD match-op PATTERN | FIELD 'in' set
+set      -> '[' value { ',' value } ']' | '@' FILENAME
+predicate-> 'success' | 'redirect' | 'error' | 'clienterror' | 'servererror'
+match-op -> '='|'~'|'<'|'<='|'>'|'>='|'!='|'in'|'@'
 */
 
 /*
@@ -36,9 +43,17 @@ type Parser struct {
 }
 
 // Parse starts building a parse tree. Covers up the
-// use of an un-exported non-terminal function.
+// use of an un-exported non-terminal function. Anything
+// but the end of the sentence after an expr is an error.
 func (p *Parser) Parse() (*tree.Node, error) {
-	return p.expr()
+	node, err := p.expr()
+	if err != nil {
+		return node, err
+	}
+	if kind, lexeme := p.lexer.NextToken(); kind != lexer.EOF && kind != lexer.EOL {
+		return node, fmt.Errorf("wanted AND, OR or the end, got %v: %q\n", kind, lexeme)
+	}
+	return node, nil
 }
 
 func (p *Parser) expr() (*tree.Node, error) {
@@ -78,6 +93,9 @@ func (p *Parser) factor() (*tree.Node, error) {
 		factor, err := p.factor()
 		return tree.NotNode(unaryOp, factor), err
 	case lexer.FIELD:
+		if _, ok := Predicates[lexeme]; ok {
+			return p.predicate()
+		}
 		return p.boolean()
 	case lexer.LPAREN:
 		p.lexer.Consume() // left paren
@@ -91,7 +109,6 @@ func (p *Parser) factor() (*tree.Node, error) {
 	default:
 		return nil, fmt.Errorf("wanted NOT, FIELD or LPAREN, got %v: %q\n", kind, lexeme)
 	}
-	return nil, nil
 }
 
 func (p *Parser) boolean() (*tree.Node, error) {
@@ -107,6 +124,10 @@ func (p *Parser) boolean() (*tree.Node, error) {
 	booleanNode := tree.NewNode(kind, lexeme)
 
 	kind, lexeme = p.lexer.NextToken()
+	if kind == lexer.LIST || kind == lexer.FILE {
+		p.lexer.Consume()
+		return setMatch(field, kind, lexeme)
+	}
 	if kind != lexer.PATTERN {
 		return nil, fmt.Errorf("wanted a PATTERN, got %v: %q\n", kind, lexeme)
 	}
@@ -114,23 +135,251 @@ func (p *Parser) boolean() (*tree.Node, error) {
 	p.lexer.Consume()
 
 	var ok bool
-	if booleanNode.FieldIndex, ok = FieldToIndex[field]; !ok {
+	if booleanNode.FieldIndex, ok = LookupField(field); !ok {
 		return nil, fmt.Errorf("no field named %q available for matching\n", field)
 	}
+	// "garbage" is "user" by its real name
+	booleanNode.Field = FieldNames[booleanNode.FieldIndex]
 
-	if booleanNode.Op == lexer.EXACT_MATCH {
+	switch booleanNode.Op {
+	case lexer.EXACT_MATCH:
 		booleanNode.ExactValue = pattern
-	} else if booleanNode.Op == lexer.REGEX_MATCH {
+	case lexer.REGEX_MATCH:
 		var err error
 		booleanNode.Pattern, err = regexp.Compile(pattern)
 		if err != nil {
 			return nil, err
 		}
+	case lexer.NUMERIC_COMPARE:
+		if TimeFields[field] {
+			var err error
+			booleanNode.Op = lexer.TIME_COMPARE
+			booleanNode.Time, err = parseTime(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %v\n", field, err)
+			}
+			break
+		}
+		if !NumericFields[field] {
+			return nil, fmt.Errorf("field %q does not allow numeric comparison %q\n", field, booleanNode.Lexeme)
+		}
+		if DurationFields[field] {
+			var err error
+			if booleanNode.Number, err = parseMicroseconds(pattern); err != nil {
+				return nil, fmt.Errorf("field %q: %v\n", field, err)
+			}
+			break
+		}
+		var err error
+		booleanNode.Number, err = strconv.ParseInt(pattern, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %q compares to integers, not %q\n", field, pattern)
+		}
+	case lexer.TIME_RANGE:
+		if !TimeFields[field] {
+			return nil, fmt.Errorf("field %q does not allow time range %q\n", field, pattern)
+		}
+		bounds := strings.Split(pattern, "..")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("time range %q needs the form start..end\n", pattern)
+		}
+		var err error
+		if booleanNode.Time, err = parseTime(bounds[0]); err != nil {
+			return nil, fmt.Errorf("field %q: %v\n", field, err)
+		}
+		if booleanNode.TimeEnd, err = parseTime(bounds[1]); err != nil {
+			return nil, fmt.Errorf("field %q: %v\n", field, err)
+		}
+		if booleanNode.TimeEnd.Before(booleanNode.Time) {
+			return nil, fmt.Errorf("time range %q ends before it starts\n", pattern)
+		}
+	case lexer.CIDR_MATCH:
+		if !AddrFields[field] {
+			return nil, fmt.Errorf("field %q does not allow network prefix match %q\n", field, pattern)
+		}
+		var err error
+		if booleanNode.Prefixes, err = parsePrefixes(pattern); err != nil {
+			return nil, fmt.Errorf("field %q: %v\n", field, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown match operator %q\n", booleanNode.Lexeme)
 	}
 
 	return booleanNode, nil
 }
 
+// setMatch makes a SET_MATCH node for a field, from a [...] list of
+// values, or from an @file of values, one per line. Blank lines in
+// the file, and lines that start with '#', don't count.
+func setMatch(field string, kind lexer.TokenType, lexeme string) (*tree.Node, error) {
+	node := tree.NewNode(lexer.SET_MATCH, "in")
+	var ok bool
+	if node.FieldIndex, ok = LookupField(field); !ok {
+		return nil, fmt.Errorf("no field named %q available for matching\n", field)
+	}
+	node.Field = FieldNames[node.FieldIndex]
+	if TimeFields[node.Field] {
+		return nil, fmt.Errorf("field %q does not allow set membership %q\n", field, lexeme)
+	}
+
+	var values []string
+	if kind == lexer.LIST {
+		if !strings.HasSuffix(lexeme, "]") {
+			return nil, fmt.Errorf("list %q needs a closing ]\n", lexeme)
+		}
+		var err error
+		if values, err = splitList(lexeme[1 : len(lexeme)-1]); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if values, err = readSetFile(strings.TrimPrefix(lexeme, "@")); err != nil {
+			return nil, fmt.Errorf("field %q: %v\n", field, err)
+		}
+	}
+
+	node.Set = make(map[string]bool)
+	for _, value := range values {
+		if value != "" {
+			node.Set[value] = true
+		}
+	}
+	if kind == lexer.LIST && len(node.Set) == 0 {
+		return nil, fmt.Errorf("list %q has no values\n", lexeme)
+	}
+	return node, nil
+}
+
+// splitList breaks up the values between the brackets of a [...]
+// list. Values end at commas, except inside a quoted value, like
+// "a, b" or 'a, b', which splitList takes the quotes off.
+func splitList(list string) ([]string, error) {
+	var values []string
+	for rest := list; ; {
+		rest = strings.TrimLeft(rest, " \t")
+		var value string
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			end := strings.IndexByte(rest[1:], rest[0]) + 1
+			if end < 1 {
+				return nil, fmt.Errorf("list value %s needs a closing %c\n", rest, rest[0])
+			}
+			value, rest = rest[1:end], strings.TrimLeft(rest[end+1:], " \t")
+			if rest != "" && rest[0] != ',' {
+				return nil, fmt.Errorf("list value %q has %q after its closing quote\n", value, rest)
+			}
+		} else {
+			comma := strings.IndexByte(rest, ',')
+			if comma < 0 {
+				comma = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:comma]), rest[comma:]
+		}
+		values = append(values, value)
+		if rest == "" {
+			return values, nil
+		}
+		rest = rest[1:] // the comma
+	}
+}
+
+// readSetFile reads the values of an @file set, a value per line.
+func readSetFile(fileName string) ([]string, error) {
+	if fileName == "" {
+		return nil, errors.New("@ needs a file name")
+	}
+	fin, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fin.Close()
+
+	var values []string
+	scanner := bufio.NewScanner(fin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	return values, scanner.Err()
+}
+
+func (p *Parser) predicate() (*tree.Node, error) {
+	_, lexeme := p.lexer.NextToken()
+	p.lexer.Consume()
+
+	node := tree.NewNode(lexer.PREDICATE, lexeme)
+	var ok bool
+	node.Field = "class"
+	if node.FieldIndex, ok = LookupField(node.Field); !ok {
+		return nil, fmt.Errorf("no status code class field for %q\n", lexeme)
+	}
+	node.Set = make(map[string]bool)
+	for _, class := range Predicates[lexeme] {
+		node.Set[class] = true
+	}
+	return node, nil
+}
+
+// parseMicroseconds reads a duration literal, either a Go duration
+// like 250ms or 1.5s, or a plain integer count of microseconds.
+func parseMicroseconds(value string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration like 500ms, or integer microseconds", value)
+	}
+	return d.Microseconds(), nil
+}
+
+// timeLayouts are the forms of time literal that a sentence
+// can compare timestamps against, most precise first.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// parseTime turns a time literal from a sentence into a time.Time.
+// A bare date means midnight UTC at the start of that day.
+func parseTime(literal string) (time.Time, error) {
+	literal = strings.TrimSpace(literal)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, literal); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("malformed time %q, want RFC3339 like 2006-01-02T15:04:05Z", literal)
+}
+
+// parsePrefixes turns a comma-separated list of network prefixes,
+// like "10.0.0.0/8, 2001:db8::/32", into netip.Prefix values. A bare
+// address means a prefix that contains only that address.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, str := range strings.Split(list, ",") {
+		str = strings.TrimSpace(str)
+		if !strings.Contains(str, "/") {
+			addr, err := netip.ParseAddr(str)
+			if err != nil {
+				return nil, fmt.Errorf("malformed address %q", str)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(str)
+		if err != nil {
+			return nil, fmt.Errorf("malformed network prefix %q", str)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
 // NewParser creates a filled in Parser struct and returns it.
 func NewParser(lxr *lexer.Lexer) *Parser {
 	return &Parser{lexer: lxr}
@@ -3,9 +3,13 @@ package parser
 import (
 	"combined/lexer"
 	"combined/tree"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func TestParser_Parse(t *testing.T) {
@@ -21,6 +25,7 @@ func TestParser_Parse(t *testing.T) {
 			want: &tree.Node{
 				Op:         lexer.EXACT_MATCH,
 				Lexeme:     "=",
+				Field:      "ipaddr",
 				FieldIndex: 0,
 				ExactValue: "abc",
 			},
@@ -32,6 +37,7 @@ func TestParser_Parse(t *testing.T) {
 			want: &tree.Node{
 				Op:         lexer.REGEX_MATCH,
 				Lexeme:     "~",
+				Field:      "timestamp",
 				FieldIndex: 2,
 				Pattern:    regexp.MustCompile(`a.b.c`),
 			},
@@ -43,6 +49,7 @@ func TestParser_Parse(t *testing.T) {
 			want: &tree.Node{
 				Op:         lexer.REGEX_MATCH,
 				Lexeme:     "~",
+				Field:      "timestamp",
 				FieldIndex: 2,
 				Pattern:    regexp.MustCompile(`a.b.c`),
 			},
@@ -84,6 +91,342 @@ func TestParser_Parse(t *testing.T) {
 			want:      nil,
 			wantErr:   true,
 		},
+		{
+			name:      "numeric comparison",
+			stringrep: "code >= /500/",
+			want: &tree.Node{
+				Op:         lexer.NUMERIC_COMPARE,
+				Lexeme:     ">=",
+				Field:      "code",
+				FieldIndex: 6,
+				Number:     500,
+				Compare:    lexer.GREATER_EQUAL,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "numeric not equal",
+			stringrep: "size!=/0/",
+			want: &tree.Node{
+				Op:         lexer.NUMERIC_COMPARE,
+				Lexeme:     "!=",
+				Field:      "size",
+				FieldIndex: 7,
+				Number:     0,
+				Compare:    lexer.NOT_EQUAL,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "duration comparison, Go duration",
+			stringrep: "duration > /1.5s/",
+			want: &tree.Node{
+				Op:         lexer.NUMERIC_COMPARE,
+				Lexeme:     ">",
+				Field:      "duration",
+				FieldIndex: 23,
+				Number:     1500000,
+				Compare:    lexer.GREATER_THAN,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "duration comparison, microseconds",
+			stringrep: "duration <= /250/",
+			want: &tree.Node{
+				Op:         lexer.NUMERIC_COMPARE,
+				Lexeme:     "<=",
+				Field:      "duration",
+				FieldIndex: 23,
+				Number:     250,
+				Compare:    lexer.LESS_EQUAL,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "duration comparison, not a duration",
+			stringrep: "duration > /slow/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "numeric comparison, non-numeric field",
+			stringrep: "url > /500/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "numeric comparison, non-numeric pattern",
+			stringrep: "size < /lots/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "timestamp comparison",
+			stringrep: "timestamp >= /2024-05-01T14:00:00Z/",
+			want: &tree.Node{
+				Op:         lexer.TIME_COMPARE,
+				Lexeme:     ">=",
+				Field:      "timestamp",
+				FieldIndex: 2,
+				Compare:    lexer.GREATER_EQUAL,
+				Time:       time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC),
+			},
+			wantErr: false,
+		},
+		{
+			name:      "timestamp range",
+			stringrep: "timestamp in /2024-05-01T14:00Z..2024-05-01T15:30Z/",
+			want: &tree.Node{
+				Op:         lexer.TIME_RANGE,
+				Lexeme:     "in",
+				Field:      "timestamp",
+				FieldIndex: 2,
+				Time:       time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC),
+				TimeEnd:    time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC),
+			},
+			wantErr: false,
+		},
+		{
+			name:      "timestamp comparison, malformed time",
+			stringrep: "timestamp < /yesterday/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "timestamp range, missing end",
+			stringrep: "timestamp in /2024-05-01T14:00Z/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "timestamp range, backwards",
+			stringrep: "timestamp in /2024-05-01T15:30Z..2024-05-01T14:00Z/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "time range, non-time field",
+			stringrep: "url in /2024-05-01T14:00Z..2024-05-01T15:30Z/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "network prefix match",
+			stringrep: "ipaddr @ /10.1.2.3/8/",
+			want: &tree.Node{
+				Op:         lexer.CIDR_MATCH,
+				Lexeme:     "@",
+				Field:      "ipaddr",
+				FieldIndex: 0,
+				Prefixes:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "network prefix list match",
+			stringrep: "ipaddr@/192.168.0.0/16, 2001:db8::/32, 10.0.0.1/",
+			want: &tree.Node{
+				Op:         lexer.CIDR_MATCH,
+				Lexeme:     "@",
+				Field:      "ipaddr",
+				FieldIndex: 0,
+				Prefixes: []netip.Prefix{
+					netip.MustParsePrefix("192.168.0.0/16"),
+					netip.MustParsePrefix("2001:db8::/32"),
+					netip.MustParsePrefix("10.0.0.1/32"),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "network prefix match, malformed prefix",
+			stringrep: "ipaddr @ /10.0.0.0/33/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "network prefix match, non-address field",
+			stringrep: "referrer @ /10.0.0.0/8/",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "bare predicate",
+			stringrep: "error",
+			want: &tree.Node{
+				Op:         lexer.PREDICATE,
+				Lexeme:     "error",
+				Field:      "class",
+				FieldIndex: 22,
+				Set:        map[string]bool{"4xx": true, "5xx": true},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "negated predicate with boolean",
+			stringrep: "-success && method = /POST/",
+			want: &tree.Node{
+				Op:     lexer.AND,
+				Lexeme: "&&",
+				Left: &tree.Node{
+					Op: lexer.NOT,
+					Left: &tree.Node{
+						Op:         lexer.PREDICATE,
+						Lexeme:     "success",
+						Field:      "class",
+						FieldIndex: 22,
+						Set:        map[string]bool{"2xx": true},
+					},
+				},
+				Right: &tree.Node{
+					Op:         lexer.EXACT_MATCH,
+					Lexeme:     "=",
+					Field:      "method",
+					FieldIndex: 3,
+					ExactValue: "POST",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "set membership, list",
+			stringrep: "ipaddr in [1.2.3.4, 5.6.7.8,2001:db8::1]",
+			want: &tree.Node{
+				Op:         lexer.SET_MATCH,
+				Lexeme:     "in",
+				Field:      "ipaddr",
+				FieldIndex: 0,
+				Set:        map[string]bool{"1.2.3.4": true, "5.6.7.8": true, "2001:db8::1": true},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "set membership, quoted list values",
+			stringrep: `useragent in ["curl/8.0", 'Wget 1.21']`,
+			want: &tree.Node{
+				Op:         lexer.SET_MATCH,
+				Lexeme:     "in",
+				Field:      "useragent",
+				FieldIndex: 9,
+				Set:        map[string]bool{"curl/8.0": true, "Wget 1.21": true},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "set membership, ] inside quotes",
+			stringrep: `url in ["/a[1]", /b]`,
+			want: &tree.Node{
+				Op:         lexer.SET_MATCH,
+				Lexeme:     "in",
+				Field:      "url",
+				FieldIndex: 4,
+				Set:        map[string]bool{"/a[1]": true, "/b": true},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "set membership, comma inside quotes",
+			stringrep: `useragent in ["Mozilla/5.0 (X11, Linux)", curl, 'a, "b"']`,
+			want: &tree.Node{
+				Op:         lexer.SET_MATCH,
+				Lexeme:     "in",
+				Field:      "useragent",
+				FieldIndex: 9,
+				Set:        map[string]bool{"Mozilla/5.0 (X11, Linux)": true, "curl": true, `a, "b"`: true},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "set membership, apostrophe inside a value",
+			stringrep: `user in [O'Brien, x]`,
+			want: &tree.Node{
+				Op:         lexer.SET_MATCH,
+				Lexeme:     "in",
+				Field:      "user",
+				FieldIndex: 1,
+				Set:        map[string]bool{"O'Brien": true, "x": true},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "set membership, unclosed quote",
+			stringrep: `url in ["/a, /b]`,
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "set membership, junk after quote",
+			stringrep: `url in ["/a"x, /b]`,
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "stray comma after list",
+			stringrep: `url in [/a], /b]`,
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "stray comma between matches",
+			stringrep: `url = /a/, method = /GET/`,
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "pattern without slashes",
+			stringrep: `code = 200`,
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "set membership, empty list",
+			stringrep: "ipaddr in [ ]",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "set membership, unclosed list",
+			stringrep: "ipaddr in [1.2.3.4",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "set membership, time field",
+			stringrep: "timestamp in [a, b]",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "set membership, missing file",
+			stringrep: "ipaddr in @/nonexistent/blocklist.txt",
+			want:      nil,
+			wantErr:   true,
+		},
+		{
+			name:      "ident field",
+			stringrep: "ident = /-/",
+			want: &tree.Node{
+				Op:         lexer.EXACT_MATCH,
+				Lexeme:     "=",
+				Field:      "ident",
+				FieldIndex: 10,
+				ExactValue: "-",
+			},
+			wantErr: false,
+		},
+		{
+			name:      "garbage is an alias of user",
+			stringrep: "garbage ~ /^bob$/",
+			want: &tree.Node{
+				Op:         lexer.REGEX_MATCH,
+				Lexeme:     "~",
+				Field:      "user",
+				FieldIndex: 1,
+				Pattern:    regexp.MustCompile(`^bob$`),
+			},
+			wantErr: false,
+		},
 		{
 			name:      "NOT lexical match",
 			stringrep: "-(url=/abc/)",
@@ -92,6 +435,7 @@ func TestParser_Parse(t *testing.T) {
 				Left: &tree.Node{
 					Op:         lexer.EXACT_MATCH,
 					Lexeme:     "=",
+					Field:      "url",
 					FieldIndex: 4,
 					ExactValue: "abc",
 				},
@@ -107,12 +451,14 @@ func TestParser_Parse(t *testing.T) {
 				Left: &tree.Node{
 					Op:         lexer.EXACT_MATCH,
 					Lexeme:     "=",
+					Field:      "url",
 					FieldIndex: 4,
 					ExactValue: "abc",
 				},
 				Right: &tree.Node{
 					Op:         lexer.EXACT_MATCH,
 					Lexeme:     "=",
+					Field:      "method",
 					FieldIndex: 3,
 					ExactValue: "GET",
 				},
@@ -128,12 +474,14 @@ func TestParser_Parse(t *testing.T) {
 				Left: &tree.Node{
 					Op:         lexer.REGEX_MATCH,
 					Lexeme:     "~",
+					Field:      "url",
 					FieldIndex: 4,
 					Pattern:    regexp.MustCompile(`abc|def`),
 				},
 				Right: &tree.Node{
 					Op:         lexer.REGEX_MATCH,
 					Lexeme:     "~",
+					Field:      "method",
 					FieldIndex: 3,
 					Pattern:    regexp.MustCompile("[Gg][Ee][Tt]"),
 				},
@@ -152,12 +500,14 @@ func TestParser_Parse(t *testing.T) {
 					Left: &tree.Node{
 						Op:         lexer.EXACT_MATCH,
 						Lexeme:     "=",
+						Field:      "ipaddr",
 						FieldIndex: 0,
 						ExactValue: "zork",
 					},
 					Right: &tree.Node{
 						Op:         lexer.REGEX_MATCH,
 						Lexeme:     "~",
+						Field:      "url",
 						FieldIndex: 4,
 						Pattern:    regexp.MustCompile(`abc|def`),
 					},
@@ -165,6 +515,7 @@ func TestParser_Parse(t *testing.T) {
 				Right: &tree.Node{
 					Op:         lexer.REGEX_MATCH,
 					Lexeme:     "~",
+					Field:      "method",
 					FieldIndex: 3,
 					Pattern:    regexp.MustCompile("[Gg][Ee][Tt]"),
 				},
@@ -188,3 +539,52 @@ func TestParser_Parse(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupField(t *testing.T) {
+	n, ok := LookupField("path")
+	if !ok || FieldNames[n] != "path" {
+		t.Errorf("LookupField(path) = %d, %v", n, ok)
+	}
+
+	n, ok = LookupField("param[utm_source]")
+	if !ok || FieldNames[n] != "param[utm_source]" {
+		t.Fatalf("LookupField(param[utm_source]) = %d, %v", n, ok)
+	}
+	if again, _ := LookupField("param[utm_source]"); again != n {
+		t.Errorf("LookupField(param[utm_source]) registered twice, %d and %d", n, again)
+	}
+
+	for _, name := range []string{"nonesuch", "nonesuch[x]", "param[]", "param["} {
+		if _, ok := LookupField(name); ok {
+			t.Errorf("LookupField(%s) found a field", name)
+		}
+	}
+}
+
+func TestParser_ParseParameterizedField(t *testing.T) {
+	p := NewParser(lexer.Lex("param[utm_source] = /newsletter/"))
+	got, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parser.Parse() error = %v", err)
+	}
+	if FieldNames[got.FieldIndex] != "param[utm_source]" || got.ExactValue != "newsletter" {
+		t.Errorf("Parser.Parse() = field %d %q, value %q", got.FieldIndex, FieldNames[got.FieldIndex], got.ExactValue)
+	}
+}
+
+func TestParser_ParseSetFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := "# scanners\n192.0.2.1\n\n  198.51.100.7  \n2001:db8::bad\n"
+	if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewParser(lexer.Lex("ipaddr in @" + fileName)).Parse()
+	if err != nil {
+		t.Fatalf("Parser.Parse() error = %v", err)
+	}
+	want := map[string]bool{"192.0.2.1": true, "198.51.100.7": true, "2001:db8::bad": true}
+	if got.Op != lexer.SET_MATCH || got.FieldIndex != 0 || !reflect.DeepEqual(got.Set, want) {
+		t.Errorf("Parser.Parse() = %s field %d %v, want SET_MATCH field 0 %v", got.Op, got.FieldIndex, got.Set, want)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat says how output writes timestamps: converted to
+// some time zone or not, in some layout, or exactly as logged.
+// It also has the units that output writes durations in.
+type timeFormat struct {
+	zone   *time.Location // nil leaves times in the zone they were logged in
+	layout string         // Go time layout, or "epoch" or "epochms"
+	asIs   bool           // write timestamp fields as they appear in the log
+	unit   time.Duration  // units of duration output, 0 means microseconds
+}
+
+// timeFormatNames are the -time-format values that aren't Go layouts
+var timeFormatNames = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"epoch":       "epoch",
+	"epochms":     "epochms",
+}
+
+// newTimeFormat decides on a timeFormat from the -r, -tz and
+// -time-format flags. Any of -tz or -time-format implies
+// reformatting timestamps, -r means RFC3339 layout.
+func newTimeFormat(rfc3339 bool, zoneName, layout string) (*timeFormat, error) {
+	tf := &timeFormat{layout: time.RFC3339}
+	if !rfc3339 && zoneName == "" && layout == "" {
+		tf.asIs = true
+	}
+	if zoneName != "" {
+		zone, err := time.LoadLocation(zoneName)
+		if err != nil {
+			return nil, fmt.Errorf("-tz: %v", err)
+		}
+		tf.zone = zone
+	}
+	if layout != "" {
+		if named, ok := timeFormatNames[strings.ToLower(layout)]; ok {
+			layout = named
+		}
+		tf.layout = layout
+	}
+	return tf, nil
+}
+
+// convert puts t in the time zone that output wants
+func (tf *timeFormat) convert(t time.Time) time.Time {
+	if tf.zone != nil {
+		return t.In(tf.zone)
+	}
+	return t
+}
+
+// format writes t in the output time zone and layout
+func (tf *timeFormat) format(t time.Time) string {
+	t = tf.convert(t)
+	switch tf.layout {
+	case "epoch":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "epochms":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format(tf.layout)
+}
+
+// numeric reports whether tf writes times as numbers
+func (tf *timeFormat) numeric() bool {
+	return tf.layout == "epoch" || tf.layout == "epochms"
+}
+
+// field formats the timestamp field of pe, or hands it
+// back as it appears in the log line if tf says to.
+func (tf *timeFormat) field(pe *parsedEntry) (string, error) {
+	if tf.asIs {
+		return pe.fields[2], nil
+	}
+	t, err := pe.timestamp()
+	if err != nil {
+		return "", err
+	}
+	return tf.format(t), nil
+}
+
+// durationUnits are the -duration-unit values
+var durationUnits = map[string]time.Duration{
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// parseDurationUnit checks the -duration-unit flag's value.
+func parseDurationUnit(name string) (time.Duration, error) {
+	unit, ok := durationUnits[name]
+	if !ok {
+		return 0, fmt.Errorf("-duration-unit wants us, ms or s, not %q", name)
+	}
+	return unit, nil
+}
+
+// duration writes value, a count of microseconds, in the output
+// units. A value that isn't an integer, like "-", comes back as is.
+func (tf *timeFormat) duration(value string) string {
+	if tf.unit <= time.Microsecond {
+		return value
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	perUnit := float64(tf.unit / time.Microsecond)
+	return strconv.FormatFloat(float64(n)/perUnit, 'f', -1, 64)
+}
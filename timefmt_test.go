@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTimeFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		rfc3339  bool
+		zone     string
+		layout   string
+		wantAsIs bool
+		wantZone string
+		want     string // layout
+		wantErr  bool
+	}{
+		{name: "no flags", wantAsIs: true, want: time.RFC3339},
+		{name: "-r", rfc3339: true, want: time.RFC3339},
+		{name: "-tz", zone: "America/Denver", wantZone: "America/Denver", want: time.RFC3339},
+		{name: "-time-format layout", layout: "15:04", want: "15:04"},
+		{name: "-time-format name", layout: "RFC3339Nano", want: time.RFC3339Nano},
+		{name: "-time-format epoch", layout: "epoch", want: "epoch"},
+		{name: "-r and -time-format", rfc3339: true, layout: "epochms", want: "epochms"},
+		{name: "bad -tz", zone: "Mars/Olympus_Mons", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tf, err := newTimeFormat(tt.rfc3339, tt.zone, tt.layout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTimeFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tf.asIs != tt.wantAsIs {
+				t.Errorf("asIs = %v, want %v", tf.asIs, tt.wantAsIs)
+			}
+			if tf.layout != tt.want {
+				t.Errorf("layout = %q, want %q", tf.layout, tt.want)
+			}
+			zone := ""
+			if tf.zone != nil {
+				zone = tf.zone.String()
+			}
+			if zone != tt.wantZone {
+				t.Errorf("zone = %q, want %q", zone, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestTimeFormat_Field(t *testing.T) {
+	pe := testEntry("10.0.0.1", "-", "[10/Oct/2023:13:55:36 -0700]", "GET", "/", "HTTP/1.1", "200", "0", "-", "curl", "-")
+	tests := []struct {
+		name    string
+		rfc3339 bool
+		zone    string
+		layout  string
+		want    string
+	}{
+		{name: "as logged", want: "[10/Oct/2023:13:55:36 -0700]"},
+		{name: "-r keeps the logged zone", rfc3339: true, want: "2023-10-10T13:55:36-07:00"},
+		{name: "-tz UTC", zone: "UTC", want: "2023-10-10T20:55:36Z"},
+		{name: "-tz converts", zone: "Asia/Tokyo", want: "2023-10-11T05:55:36+09:00"},
+		{name: "-tz and layout", zone: "UTC", layout: "2006-01-02 15:04:05", want: "2023-10-10 20:55:36"},
+		{name: "epoch", layout: "epoch", want: "1696971336"},
+		{name: "epochms", layout: "epochms", want: "1696971336000"},
+		{name: "-tz doesn't change epoch", zone: "Asia/Tokyo", layout: "epoch", want: "1696971336"},
+		{name: "-r and layout", rfc3339: true, layout: "Jan 2 15:04", want: "Oct 10 13:55"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tf, err := newTimeFormat(tt.rfc3339, tt.zone, tt.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tf.field(pe)
+			if err != nil {
+				t.Fatalf("field() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("field() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	tf, _ := newTimeFormat(true, "", "")
+	if _, err := tf.field(testEntry("10.0.0.1", "-", "[yesterday]")); err == nil {
+		t.Errorf("field() of a bad timestamp did not fail")
+	}
+}
+
+func TestTimeFormat_Duration(t *testing.T) {
+	tests := []struct {
+		unit  time.Duration
+		value string
+		want  string
+	}{
+		{0, "1500", "1500"},
+		{time.Microsecond, "1500", "1500"},
+		{time.Millisecond, "1500", "1.5"},
+		{time.Second, "2000000", "2"},
+		{time.Millisecond, "-", "-"},
+		{time.Millisecond, "", ""},
+	}
+	for _, tt := range tests {
+		tf := &timeFormat{unit: tt.unit}
+		if got := tf.duration(tt.value); got != tt.want {
+			t.Errorf("duration(%q) in %v = %q, want %q", tt.value, tt.unit, got, tt.want)
+		}
+	}
+}
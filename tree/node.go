@@ -6,7 +6,11 @@ package tree
 import (
 	"fmt"
 	"io"
+	"net/netip"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"combined/lexer"
 )
@@ -17,9 +21,16 @@ import (
 type Node struct {
 	Op         lexer.TokenType
 	Lexeme     string
+	Field      string // name of the field that FieldIndex indexes
 	FieldIndex int
 	Pattern    *regexp.Regexp
 	ExactValue string
+	Number     int64
+	Compare    lexer.TokenType
+	Time       time.Time
+	TimeEnd    time.Time
+	Prefixes   []netip.Prefix
+	Set        map[string]bool
 	Left       *Node
 	Right      *Node
 }
@@ -32,12 +43,33 @@ func NewNode(op lexer.TokenType, lexeme string) *Node {
 			op = lexer.EXACT_MATCH
 		case "~":
 			op = lexer.REGEX_MATCH
+		case "in":
+			op = lexer.TIME_RANGE
+		case "@":
+			op = lexer.CIDR_MATCH
 		}
 	}
-	return &Node{
+	node := &Node{
 		Op:     op,
 		Lexeme: lexeme,
 	}
+	if op == lexer.MATCH_OP {
+		if cmp, ok := comparisons[lexeme]; ok {
+			node.Op = lexer.NUMERIC_COMPARE
+			node.Compare = cmp
+		}
+	}
+	return node
+}
+
+// comparisons maps the lexemes of the ordering operators
+// to the kind of comparison a node will make.
+var comparisons = map[string]lexer.TokenType{
+	"<":  lexer.LESS_THAN,
+	"<=": lexer.LESS_EQUAL,
+	">":  lexer.GREATER_THAN,
+	">=": lexer.GREATER_EQUAL,
+	"!=": lexer.NOT_EQUAL,
 }
 
 // NotNode handles "-something" situtations.
@@ -63,11 +95,16 @@ func (p *Node) Print(w io.Writer) {
 		return
 	}
 
-	if p.Op == lexer.FIELD || p.Op == lexer.PATTERN {
+	if p.Op == lexer.FIELD || p.Op == lexer.PATTERN || p.Op == lexer.PREDICATE {
 		fmt.Fprintf(w, "%s", p.Lexeme)
 		return
 	}
 
+	if p.Left == nil && p.Right == nil {
+		p.printMatch(w)
+		return
+	}
+
 	w.Write([]byte{'('})
 	p.Left.Print(w)
 	fmt.Fprintf(w, " %s ", p.Lexeme)
@@ -75,6 +112,59 @@ func (p *Node) Print(w io.Writer) {
 	w.Write([]byte{')'})
 }
 
+// printMatch writes a leaf node in match sentence syntax,
+// with the field's real name, and the pattern as parsed.
+func (p *Node) printMatch(w io.Writer) {
+	switch p.Op {
+	case lexer.EXACT_MATCH:
+		fmt.Fprintf(w, "%s = /%s/", p.Field, p.ExactValue)
+	case lexer.REGEX_MATCH:
+		fmt.Fprintf(w, "%s ~ /%s/", p.Field, p.Pattern)
+	case lexer.NUMERIC_COMPARE:
+		fmt.Fprintf(w, "%s %s /%d/", p.Field, p.Lexeme, p.Number)
+	case lexer.TIME_COMPARE:
+		fmt.Fprintf(w, "%s %s /%s/", p.Field, p.Lexeme, p.Time.Format(time.RFC3339Nano))
+	case lexer.TIME_RANGE:
+		fmt.Fprintf(w, "%s in /%s..%s/", p.Field, p.Time.Format(time.RFC3339Nano), p.TimeEnd.Format(time.RFC3339Nano))
+	case lexer.CIDR_MATCH:
+		prefixes := make([]string, len(p.Prefixes))
+		for i, prefix := range p.Prefixes {
+			prefixes[i] = prefix.String()
+		}
+		fmt.Fprintf(w, "%s @ /%s/", p.Field, strings.Join(prefixes, ", "))
+	case lexer.SET_MATCH:
+		values := p.SetValues()
+		for i := range values {
+			values[i] = listValue(values[i])
+		}
+		fmt.Fprintf(w, "%s in [%s]", p.Field, strings.Join(values, ", "))
+	default:
+		fmt.Fprintf(w, "%s", p.Lexeme)
+	}
+}
+
+// SetValues are the keys of p.Set, in sorted order.
+func (p *Node) SetValues() []string {
+	values := make([]string, 0, len(p.Set))
+	for value := range p.Set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// listValue quotes a value of a [...] list if it needs quotes
+// to come out of the lexer and parser as the same value.
+func listValue(value string) string {
+	if !strings.ContainsAny(value, ",] \t") && !strings.HasPrefix(value, `"`) && !strings.HasPrefix(value, "'") {
+		return value
+	}
+	if strings.Contains(value, `"`) {
+		return "'" + value + "'"
+	}
+	return `"` + value + `"`
+}
+
 func (p *Node) String() string {
 	return p.Lexeme
 }
@@ -0,0 +1,174 @@
+package tree
+
+import (
+	"sort"
+	"strings"
+
+	"combined/lexer"
+)
+
+// Optimize rewrites a parse tree into one that evaluates to the same
+// truth value for every log line, but does less work doing it:
+//
+//   - double negations, "--x", become "x"
+//   - exact matches, and predicates, of the same field in an OR chain
+//     become a single SET_MATCH, one map lookup
+//   - AND and OR chains check their operands cheapest first, so that
+//     short circuit evaluation skips the expensive ones more often
+//
+// Reordering is safe because matching a field has no side effects.
+// Optimize reuses leaf nodes of the tree it's given, but builds new
+// AND and OR nodes, so the original tree still makes sense after.
+func Optimize(node *Node) *Node {
+	if node == nil {
+		return nil
+	}
+	switch node.Op {
+	case lexer.NOT:
+		child := Optimize(node.Left)
+		if child != nil && child.Op == lexer.NOT {
+			return child.Left
+		}
+		return NotNode(node.Lexeme, child)
+	case lexer.AND, lexer.OR:
+		operands := chain(node, node.Op, nil)
+		if node.Op == lexer.OR {
+			operands = mergeSets(operands)
+		}
+		sort.SliceStable(operands, func(i, j int) bool {
+			return cost(operands[i]) < cost(operands[j])
+		})
+		root := operands[0]
+		for _, operand := range operands[1:] {
+			root = &Node{Op: node.Op, Lexeme: node.Lexeme, Left: root, Right: operand}
+		}
+		return root
+	}
+	return node
+}
+
+// chain collects the optimized operands of a run of op nodes, so
+// that "a && (b && c)" and "(a && b) && c" both have operands a, b, c.
+func chain(node *Node, op lexer.TokenType, operands []*Node) []*Node {
+	for _, child := range []*Node{node.Left, node.Right} {
+		if child != nil && child.Op != op {
+			// "--(a && b)" can turn into an AND
+			child = Optimize(child)
+		}
+		if child != nil && child.Op == op {
+			operands = chain(child, op, operands)
+			continue
+		}
+		operands = append(operands, child)
+	}
+	return operands
+}
+
+// mergeSets replaces OR operands that check one field for one of
+// some values with a single SET_MATCH node, where the SET_MATCH
+// node's Set has all the values. A field with only one such
+// operand keeps it as it is.
+func mergeSets(operands []*Node) []*Node {
+	count := make(map[int]int)
+	for _, operand := range operands {
+		if isSetLike(operand) {
+			count[operand.FieldIndex]++
+		}
+	}
+
+	merged := make(map[int]*Node)
+	var out []*Node
+	for _, operand := range operands {
+		if !isSetLike(operand) || count[operand.FieldIndex] < 2 {
+			out = append(out, operand)
+			continue
+		}
+		set, ok := merged[operand.FieldIndex]
+		if !ok {
+			set = &Node{
+				Op:         lexer.SET_MATCH,
+				Lexeme:     "in",
+				Field:      operand.Field,
+				FieldIndex: operand.FieldIndex,
+				Set:        make(map[string]bool),
+			}
+			merged[operand.FieldIndex] = set
+			out = append(out, set)
+		}
+		if operand.Op == lexer.EXACT_MATCH {
+			set.Set[operand.ExactValue] = true
+			continue
+		}
+		for value := range operand.Set {
+			set.Set[value] = true
+		}
+	}
+	return out
+}
+
+// isSetLike says whether node is true when a field has one of a set
+// of values, and so could be part of a SET_MATCH.
+func isSetLike(node *Node) bool {
+	switch node.Op {
+	case lexer.EXACT_MATCH, lexer.SET_MATCH, lexer.PREDICATE:
+		return true
+	}
+	return false
+}
+
+// cost estimates how much work evaluating node takes. The numbers
+// only matter relative to each other: string comparisons and map
+// lookups are cheap, parsing an address or time less so, and
+// regular expressions are the most expensive. Getting the value
+// of some fields costs extra, as fieldCosts has it.
+func cost(node *Node) int {
+	if node == nil {
+		return 0
+	}
+	switch node.Op {
+	case lexer.EXACT_MATCH, lexer.SET_MATCH, lexer.PREDICATE:
+		return 1 + fieldCost(node.Field)
+	case lexer.NUMERIC_COMPARE:
+		return 2 + fieldCost(node.Field)
+	case lexer.CIDR_MATCH:
+		return 3 + fieldCost(node.Field)
+	case lexer.TIME_COMPARE, lexer.TIME_RANGE:
+		return 4 + fieldCost(node.Field)
+	case lexer.REGEX_MATCH:
+		return 10 + fieldCost(node.Field)
+	case lexer.NOT:
+		return cost(node.Left)
+	case lexer.AND, lexer.OR:
+		return cost(node.Left) + cost(node.Right)
+	}
+	return 10
+}
+
+// fieldCosts are for derived fields, which take work beyond picking
+// a string out of a log line: parsing the url or referrer field, or
+// running user agent rules, which are regular expressions. Lines
+// only do that work once, for the first such field a match asks for,
+// but the first one is all that short circuit evaluation can skip.
+var fieldCosts = map[string]int{
+	"path":        3,
+	"query":       3,
+	"ext":         3,
+	"param":       3,
+	"refhost":     3,
+	"refpath":     3,
+	"refscheme":   3,
+	"refexternal": 3,
+	"browser":     10,
+	"os":          10,
+	"device":      10,
+	"isbot":       10,
+}
+
+// fieldCost looks up a field's extra cost, by family
+// for fields like param[utm_source].
+func fieldCost(field string) int {
+	if open := strings.IndexByte(field, '['); open > 0 {
+		field = field[:open]
+	}
+	return fieldCosts[field]
+}
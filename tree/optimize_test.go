@@ -0,0 +1,130 @@
+package tree
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"combined/lexer"
+)
+
+func exact(field string, index int, value string) *Node {
+	return &Node{Op: lexer.EXACT_MATCH, Lexeme: "=", Field: field, FieldIndex: index, ExactValue: value}
+}
+
+func regex(field string, index int, pattern string) *Node {
+	return &Node{Op: lexer.REGEX_MATCH, Lexeme: "~", Field: field, FieldIndex: index, Pattern: regexp.MustCompile(pattern)}
+}
+
+func binary(op lexer.TokenType, left, right *Node) *Node {
+	lexeme := "&&"
+	if op == lexer.OR {
+		lexeme = "||"
+	}
+	return &Node{Op: op, Lexeme: lexeme, Left: left, Right: right}
+}
+
+func printed(n *Node) string {
+	var buf bytes.Buffer
+	n.Print(&buf)
+	return buf.String()
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *Node
+		want string
+	}{
+		{
+			name: "regex moves after exact match",
+			in:   binary(lexer.AND, regex("useragent", 9, `.*(bot|crawl).*`), exact("code", 6, "404")),
+			want: "(code = /404/ && useragent ~ /.*(bot|crawl).*/)",
+		},
+		{
+			name: "user agent field moves after numeric compare",
+			in: binary(lexer.AND, exact("browser", 18, "Firefox"),
+				&Node{Op: lexer.NUMERIC_COMPARE, Lexeme: ">", Field: "code", FieldIndex: 6, Compare: lexer.GREATER_THAN, Number: 399}),
+			want: "(code > /399/ && browser = /Firefox/)",
+		},
+		{
+			name: "url field moves after plain field",
+			in:   binary(lexer.OR, exact("param[utm_source]", 24, "mail"), exact("method", 3, "GET")),
+			want: "(method = /GET/ || param[utm_source] = /mail/)",
+		},
+		{
+			name: "user agent field costs at least a regex",
+			in:   binary(lexer.AND, exact("isbot", 21, "true"), regex("useragent", 9, `curl`)),
+			want: "(useragent ~ /curl/ && isbot = /true/)",
+		},
+		{
+			name: "double negation",
+			in:   NotNode("-", NotNode("-", exact("method", 3, "GET"))),
+			want: "method = /GET/",
+		},
+		{
+			name: "triple negation",
+			in:   NotNode("-", NotNode("-", NotNode("-", exact("method", 3, "GET")))),
+			want: "-method = /GET/",
+		},
+		{
+			name: "exact matches in OR chain become a set",
+			in: binary(lexer.OR,
+				binary(lexer.OR, exact("method", 3, "GET"), regex("url", 4, `x`)),
+				exact("method", 3, "HEAD")),
+			want: "(method in [GET, HEAD] || url ~ /x/)",
+		},
+		{
+			name: "predicate joins a set",
+			in: binary(lexer.OR,
+				&Node{Op: lexer.PREDICATE, Lexeme: "error", Field: "class", FieldIndex: 22, Set: map[string]bool{"4xx": true, "5xx": true}},
+				exact("class", 22, "3xx")),
+			want: "class in [3xx, 4xx, 5xx]",
+		},
+		{
+			name: "set values that need quotes get them",
+			in: binary(lexer.OR,
+				binary(lexer.OR, exact("useragent", 9, "Mozilla/5.0 (X11, Linux)"), exact("useragent", 9, `say "hi"`)),
+				exact("useragent", 9, "curl")),
+			want: `useragent in ["Mozilla/5.0 (X11, Linux)", curl, 'say "hi"']`,
+		},
+		{
+			name: "exact matches of different fields stay apart",
+			in:   binary(lexer.OR, exact("method", 3, "GET"), exact("code", 6, "200")),
+			want: "(method = /GET/ || code = /200/)",
+		},
+		{
+			name: "exact matches in AND chain stay apart",
+			in:   binary(lexer.AND, exact("method", 3, "GET"), exact("method", 3, "HEAD")),
+			want: "(method = /GET/ && method = /HEAD/)",
+		},
+		{
+			name: "right-nested chain flattens",
+			in: binary(lexer.AND, regex("url", 4, `a`),
+				binary(lexer.AND, regex("referrer", 8, `b`), exact("ipaddr", 0, "10.0.0.1"))),
+			want: "((ipaddr = /10.0.0.1/ && url ~ /a/) && referrer ~ /b/)",
+		},
+		{
+			name: "negated chain flattens into its parent",
+			in: binary(lexer.AND, regex("url", 4, `a`),
+				NotNode("-", NotNode("-", binary(lexer.AND, regex("referrer", 8, `b`), exact("code", 6, "200"))))),
+			want: "((code = /200/ && url ~ /a/) && referrer ~ /b/)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := printed(Optimize(tt.in)); got != tt.want {
+				t.Errorf("Optimize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptimize_LeavesOriginal(t *testing.T) {
+	in := binary(lexer.OR, regex("url", 4, `x`), binary(lexer.OR, exact("method", 3, "GET"), exact("method", 3, "PUT")))
+	before := printed(in)
+	Optimize(in)
+	if after := printed(in); after != before {
+		t.Errorf("Optimize() changed its input from %s to %s", before, after)
+	}
+}
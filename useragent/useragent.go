@@ -0,0 +1,143 @@
+package useragent
+
+// Classify HTTP user agent strings by browser, operating
+// system, device, and whether they're a bot, using a table
+// of regular expression rules.
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+//go:embed rules.txt
+var defaultRules string
+
+// Class is what a set of Rules makes of a user agent string.
+// Bots have their name as Browser, if no browser rule matches.
+// Device is "desktop" unless some rule says otherwise,
+// or "bot" for bots.
+type Class struct {
+	Browser string
+	OS      string
+	Device  string
+	Bot     bool
+}
+
+// Rules holds classification rules, in order, by kind
+type Rules struct {
+	bot, browser, os, device []rule
+}
+
+type rule struct {
+	value string
+	re    *regexp.Regexp
+}
+
+// Default returns the rules built into the program.
+func Default() *Rules {
+	r, err := Parse(strings.NewReader(defaultRules))
+	if err != nil {
+		panic(fmt.Sprintf("built-in user agent rules: %v", err))
+	}
+	return r
+}
+
+// Parse reads rules in the format of the built-in rules.txt: a kind,
+// a value, and a regular expression per line. Blank lines and lines
+// starting with '#' get ignored.
+func Parse(in io.Reader) (*Rules, error) {
+	r := &Rules{}
+	scanner := bufio.NewScanner(in)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, rest := cutSpace(line)
+		value, pattern := cutSpace(rest)
+		if kind == "" || value == "" || pattern == "" {
+			return nil, fmt.Errorf("line %d: want kind, value and regular expression", lineNumber)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		rl := rule{value: value, re: re}
+		switch kind {
+		case "bot":
+			r.bot = append(r.bot, rl)
+		case "browser":
+			r.browser = append(r.browser, rl)
+		case "os":
+			r.os = append(r.os, rl)
+		case "device":
+			r.device = append(r.device, rl)
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind of rule %q", lineNumber, kind)
+		}
+	}
+	return r, scanner.Err()
+}
+
+// Prepend puts the rules of more ahead of the rules of r,
+// so that the rules of more get the first chance to match.
+func (r *Rules) Prepend(more *Rules) {
+	r.bot = slices.Concat(more.bot, r.bot)
+	r.browser = slices.Concat(more.browser, r.browser)
+	r.os = slices.Concat(more.os, r.os)
+	r.device = slices.Concat(more.device, r.device)
+}
+
+// cutSpace splits s at its first run of white space
+func cutSpace(s string) (string, string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// Classify decides what kind of client sent a user agent string.
+// A missing user agent, "-" in a log file, gets an empty Class.
+func (r *Rules) Classify(userAgent string) Class {
+	var c Class
+	if userAgent == "" || userAgent == "-" {
+		return c
+	}
+	botName := first(r.bot, userAgent)
+	c.Bot = botName != ""
+	c.Browser = first(r.browser, userAgent)
+	c.OS = first(r.os, userAgent)
+	c.Device = first(r.device, userAgent)
+
+	if c.Bot {
+		if c.Browser == "" {
+			c.Browser = botName
+		}
+		c.Device = "bot"
+	}
+	if c.Device == "" {
+		c.Device = "desktop"
+	}
+	return c
+}
+
+// first finds the value of the first rule that matches userAgent
+func first(rules []rule, userAgent string) string {
+	for i := range rules {
+		if rules[i].re.MatchString(userAgent) {
+			return rules[i].value
+		}
+	}
+	return ""
+}
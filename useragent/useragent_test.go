@@ -0,0 +1,113 @@
+package useragent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRules_Classify(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      Class
+	}{
+		{
+			name:      "Firefox on Linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want:      Class{Browser: "Firefox", OS: "Linux", Device: "desktop"},
+		},
+		{
+			name:      "Chrome on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      Class{Browser: "Chrome", OS: "Windows", Device: "desktop"},
+		},
+		{
+			name:      "Edge on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want:      Class{Browser: "Edge", OS: "Windows", Device: "desktop"},
+		},
+		{
+			name:      "Safari on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want:      Class{Browser: "Safari", OS: "iOS", Device: "mobile"},
+		},
+		{
+			name:      "Chrome on Android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X700 Tablet) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      Class{Browser: "Chrome", OS: "Android", Device: "tablet"},
+		},
+		{
+			name:      "Googlebot",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      Class{Browser: "Googlebot", Device: "bot", Bot: true},
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.0.1",
+			want:      Class{Browser: "curl", Device: "bot", Bot: true},
+		},
+		{
+			name:      "missing user agent",
+			userAgent: "-",
+			want:      Class{},
+		},
+	}
+	rules := Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Classify(tt.userAgent); got != tt.want {
+				t.Errorf("Rules.Classify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{
+			name:  "comments, blank lines and tabs",
+			rules: "# comment\n\nbot\tZorkBot\t(?i)zork bot\n",
+		},
+		{
+			name:    "unknown kind",
+			rules:   "robot ZorkBot zork\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing regular expression",
+			rules:   "bot ZorkBot\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad regular expression",
+			rules:   "bot ZorkBot zork[\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.rules))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRules_Prepend(t *testing.T) {
+	extra, err := Parse(strings.NewReader("bot ZorkBot (?i)zork\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := Default()
+	rules.Prepend(extra)
+	got := rules.Classify("Mozilla/5.0 (compatible; Zork/1.0) Firefox/125.0")
+	want := Class{Browser: "Firefox", Device: "bot", Bot: true}
+	if got != want {
+		t.Errorf("Rules.Classify() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+// Package vm compiles the parse tree of a match sentence into a flat
+// program of instructions, and runs programs against log lines.
+//
+// Programs have a single true/false register, the flag. Match
+// instructions set the flag, NOT inverts it, and the jump
+// instructions test it. AND and OR compile to jumps over their
+// right-hand sides, so short circuit evaluation needs no recursion.
+// The flag's value when the program runs off its end is the result.
+package vm
+
+import (
+	"combined/lexer"
+	"combined/parser"
+	"combined/tree"
+	"fmt"
+	"io"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Opcode says what an Instruction does
+type Opcode uint8
+
+// EXACT and others: all the kinds of instructions
+const (
+	EXACT      Opcode = iota // field equals Strings[Arg]
+	REGEX                    // field matches Regexps[Arg]
+	NUMERIC                  // field, as an integer, compares to Numbers[Arg]
+	TIME                     // timestamp compares to Times[Arg]
+	TIME_RANGE               // timestamp is in Times[Arg] to Times[Arg+1]
+	CIDR                     // address is in one of Prefixes[Arg]
+	IN_SET                   // field is one of the keys of Sets[Arg]
+	NOT                      // invert the flag
+	JUMP_FALSE               // go to instruction Arg if the flag is false
+	JUMP_TRUE                // go to instruction Arg if the flag is true
+)
+
+func (op Opcode) String() string {
+	switch op {
+	case EXACT:
+		return "EXACT"
+	case REGEX:
+		return "REGEX"
+	case NUMERIC:
+		return "NUMERIC"
+	case TIME:
+		return "TIME"
+	case TIME_RANGE:
+		return "TIME_RANGE"
+	case CIDR:
+		return "CIDR"
+	case IN_SET:
+		return "IN_SET"
+	case NOT:
+		return "NOT"
+	case JUMP_FALSE:
+		return "JUMP_FALSE"
+	case JUMP_TRUE:
+		return "JUMP_TRUE"
+	}
+	return "UNKNOWN"
+}
+
+// Instruction is a single step of a Program. Operands that
+// aren't integers live in the Program's tables, which Arg indexes.
+type Instruction struct {
+	Op      Opcode
+	Compare lexer.TokenType // NUMERIC and TIME: LESS_THAN, NOT_EQUAL, etc
+	Field   int             // index of the field the instruction checks
+	Arg     int             // table index, or jump target
+}
+
+// Program is a compiled match sentence. Nothing changes a Program
+// once Compile returns it, so any number of goroutines can Run
+// the same Program, and callers can keep it around for reuse.
+type Program struct {
+	Code     []Instruction
+	Strings  []string
+	Regexps  []*regexp.Regexp
+	Numbers  []int64
+	Times    []time.Time
+	Prefixes [][]netip.Prefix
+	Sets     []map[string]bool
+}
+
+// Compile turns the parse tree of a match sentence into a Program.
+func Compile(root *tree.Node) (*Program, error) {
+	p := &Program{}
+	if err := p.compile(root); err != nil {
+		return nil, err
+	}
+	p.threadJumps()
+	return p, nil
+}
+
+func (p *Program) compile(node *tree.Node) error {
+	if node == nil {
+		return fmt.Errorf("reached nil node in error")
+	}
+	switch node.Op {
+	case lexer.AND, lexer.OR:
+		if err := p.compile(node.Left); err != nil {
+			return err
+		}
+		jump := len(p.Code)
+		op := JUMP_FALSE
+		if node.Op == lexer.OR {
+			op = JUMP_TRUE
+		}
+		p.emit(Instruction{Op: op})
+		if err := p.compile(node.Right); err != nil {
+			return err
+		}
+		p.Code[jump].Arg = len(p.Code)
+	case lexer.NOT:
+		if err := p.compile(node.Left); err != nil {
+			return err
+		}
+		p.emit(Instruction{Op: NOT})
+	case lexer.EXACT_MATCH:
+		p.Strings = append(p.Strings, node.ExactValue)
+		p.emit(Instruction{Op: EXACT, Field: node.FieldIndex, Arg: len(p.Strings) - 1})
+	case lexer.REGEX_MATCH:
+		p.Regexps = append(p.Regexps, node.Pattern)
+		p.emit(Instruction{Op: REGEX, Field: node.FieldIndex, Arg: len(p.Regexps) - 1})
+	case lexer.NUMERIC_COMPARE:
+		p.Numbers = append(p.Numbers, node.Number)
+		p.emit(Instruction{Op: NUMERIC, Compare: node.Compare, Field: node.FieldIndex, Arg: len(p.Numbers) - 1})
+	case lexer.TIME_COMPARE:
+		p.Times = append(p.Times, node.Time)
+		p.emit(Instruction{Op: TIME, Compare: node.Compare, Field: node.FieldIndex, Arg: len(p.Times) - 1})
+	case lexer.TIME_RANGE:
+		p.Times = append(p.Times, node.Time, node.TimeEnd)
+		p.emit(Instruction{Op: TIME_RANGE, Field: node.FieldIndex, Arg: len(p.Times) - 2})
+	case lexer.CIDR_MATCH:
+		p.Prefixes = append(p.Prefixes, node.Prefixes)
+		p.emit(Instruction{Op: CIDR, Field: node.FieldIndex, Arg: len(p.Prefixes) - 1})
+	case lexer.PREDICATE, lexer.SET_MATCH:
+		p.Sets = append(p.Sets, node.Set)
+		p.emit(Instruction{Op: IN_SET, Field: node.FieldIndex, Arg: len(p.Sets) - 1})
+	default:
+		return fmt.Errorf("reached node with Type %s in error", node.Op)
+	}
+	return nil
+}
+
+func (p *Program) emit(in Instruction) {
+	p.Code = append(p.Code, in)
+}
+
+// threadJumps points jumps that land on other jumps straight at
+// where they'd end up. In "a && b && c", a false "a" jumps to the
+// JUMP_FALSE after "b", which can only jump again, to the end.
+func (p *Program) threadJumps() {
+	for i := range p.Code {
+		in := &p.Code[i]
+		if in.Op != JUMP_FALSE && in.Op != JUMP_TRUE {
+			continue
+		}
+		for in.Arg < len(p.Code) {
+			next := p.Code[in.Arg]
+			if next.Op == in.Op {
+				// same test, same flag: it jumps too
+				in.Arg = next.Arg
+			} else if next.Op == JUMP_FALSE || next.Op == JUMP_TRUE {
+				// opposite test, same flag: it falls through
+				in.Arg++
+			} else {
+				break
+			}
+		}
+	}
+}
+
+// Dump writes an assembly-language listing of p on w,
+// an instruction per line.
+func (p *Program) Dump(w io.Writer) {
+	for pc, in := range p.Code {
+		line := fmt.Sprintf("%4d  %-10s  %s", pc, in.Op, p.operands(in))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
+
+// operands describes what an instruction checks, in something
+// like the notation of the match sentence it came from.
+func (p *Program) operands(in Instruction) string {
+	field := ""
+	if in.Field >= 0 && in.Field < len(parser.FieldNames) {
+		field = parser.FieldNames[in.Field]
+	}
+	switch in.Op {
+	case EXACT:
+		return fmt.Sprintf("%s = %q", field, p.Strings[in.Arg])
+	case REGEX:
+		return fmt.Sprintf("%s ~ /%s/", field, p.Regexps[in.Arg])
+	case NUMERIC:
+		return fmt.Sprintf("%s %s %d", field, symbols[in.Compare], p.Numbers[in.Arg])
+	case TIME:
+		return fmt.Sprintf("%s %s %s", field, symbols[in.Compare], p.Times[in.Arg].Format(time.RFC3339))
+	case TIME_RANGE:
+		return fmt.Sprintf("%s in %s..%s", field, p.Times[in.Arg].Format(time.RFC3339), p.Times[in.Arg+1].Format(time.RFC3339))
+	case CIDR:
+		return fmt.Sprintf("%s @ %v", field, p.Prefixes[in.Arg])
+	case IN_SET:
+		values := make([]string, 0, len(p.Sets[in.Arg]))
+		for value := range p.Sets[in.Arg] {
+			values = append(values, strconv.Quote(value))
+		}
+		sort.Strings(values)
+		return fmt.Sprintf("%s in [%s]", field, strings.Join(values, ", "))
+	case JUMP_FALSE, JUMP_TRUE:
+		return fmt.Sprintf("%d", in.Arg)
+	}
+	return ""
+}
+
+// symbols are the match sentence operators for comparisons
+var symbols = map[lexer.TokenType]string{
+	lexer.LESS_THAN:     "<",
+	lexer.LESS_EQUAL:    "<=",
+	lexer.GREATER_THAN:  ">",
+	lexer.GREATER_EQUAL: ">=",
+	lexer.NOT_EQUAL:     "!=",
+}
@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"cmp"
+	"combined/lexer"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// Record is a log line, broken into fields, that a Program runs against.
+type Record interface {
+	// Value is field n, or "" if the record doesn't have field n
+	Value(n int) string
+	// Time is the record's parsed timestamp
+	Time() (time.Time, error)
+	// Addr is the record's parsed client IP address
+	Addr() (netip.Addr, error)
+}
+
+// Run executes p against r, and says whether r matches.
+// Values that don't parse, like "-" for a size, don't
+// match any comparison.
+func (p *Program) Run(r Record) bool {
+	flag := false
+	code := p.Code
+	for pc := 0; pc < len(code); {
+		in := &code[pc]
+		pc++
+		switch in.Op {
+		case EXACT:
+			flag = r.Value(in.Field) == p.Strings[in.Arg]
+		case REGEX:
+			flag = p.Regexps[in.Arg].MatchString(r.Value(in.Field))
+		case NUMERIC:
+			n, err := strconv.ParseInt(r.Value(in.Field), 10, 64)
+			flag = err == nil && compare(in.Compare, cmp.Compare(n, p.Numbers[in.Arg]))
+		case TIME:
+			t, err := r.Time()
+			flag = err == nil && compare(in.Compare, t.Compare(p.Times[in.Arg]))
+		case TIME_RANGE:
+			t, err := r.Time()
+			flag = err == nil && !t.Before(p.Times[in.Arg]) && !t.After(p.Times[in.Arg+1])
+		case CIDR:
+			flag = false
+			if addr, err := r.Addr(); err == nil {
+				for _, prefix := range p.Prefixes[in.Arg] {
+					if prefix.Contains(addr) {
+						flag = true
+						break
+					}
+				}
+			}
+		case IN_SET:
+			flag = p.Sets[in.Arg][r.Value(in.Field)]
+		case NOT:
+			flag = !flag
+		case JUMP_FALSE:
+			if !flag {
+				pc = in.Arg
+			}
+		case JUMP_TRUE:
+			if flag {
+				pc = in.Arg
+			}
+		}
+	}
+	return flag
+}
+
+// compare turns the result of a three-way comparison into
+// the truth value that a comparison operator asks for.
+func compare(op lexer.TokenType, c int) bool {
+	switch op {
+	case lexer.LESS_THAN:
+		return c < 0
+	case lexer.LESS_EQUAL:
+		return c <= 0
+	case lexer.GREATER_THAN:
+		return c > 0
+	case lexer.GREATER_EQUAL:
+		return c >= 0
+	case lexer.NOT_EQUAL:
+		return c != 0
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package vm
+
+import (
+	"bytes"
+	"combined/lexer"
+	"combined/parser"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// record is a Record with fields in the parser's "combined" order
+type record []string
+
+func (r record) Value(n int) string {
+	if n < len(r) {
+		return r[n]
+	}
+	return ""
+}
+
+func (r record) Time() (time.Time, error) {
+	return time.Parse(`[02/Jan/2006:15:04:05 -0700]`, r[2])
+}
+
+func (r record) Addr() (netip.Addr, error) {
+	return netip.ParseAddr(r[0])
+}
+
+var testRecord = func() record {
+	r := record{
+		"10.1.2.3", "-", "[01/May/2024:14:30:00 +0000]", "POST", "/login.php", "HTTP/1.1", "403", "-", "-", "curl/8.0", "-",
+	}
+	// the derived status code class, for predicates
+	class, _ := parser.LookupField("class")
+	r = append(r, make([]string, class+1-len(r))...)
+	r[class] = "4xx"
+	return r
+}()
+
+func compile(t *testing.T, sentence string) *Program {
+	t.Helper()
+	root, err := parser.NewParser(lexer.Lex(sentence)).Parse()
+	if err != nil {
+		t.Fatalf("parsing %q: %v", sentence, err)
+	}
+	prog, err := Compile(root)
+	if err != nil {
+		t.Fatalf("compiling %q: %v", sentence, err)
+	}
+	return prog
+}
+
+func TestProgram_Run(t *testing.T) {
+	tests := []struct {
+		sentence string
+		want     bool
+	}{
+		{"method = /POST/", true},
+		{"method = /GET/", false},
+		{"url ~ /\\.php$/", true},
+		{"-url ~ /\\.php$/", false},
+		{"code >= /400/", true},
+		{"code < /400/", false},
+		{"size > /0/", false},
+		{"size != /0/", false},
+		{"timestamp > /2024-05-01T14:00Z/", true},
+		{"timestamp in /2024-05-01T15:00Z..2024-05-01T16:00Z/", false},
+		{"ipaddr @ /10.0.0.0/8/", true},
+		{"ipaddr @ /192.168.0.0/16, 2001:db8::/32/", false},
+		{"error", true},
+		{"success || redirect", false},
+		{"method = /GET/ && url ~ /php/", false},
+		{"method = /GET/ || url ~ /php/", true},
+		{"method = /POST/ && url ~ /php/ && code = /403/", true},
+		{"method = /POST/ && url ~ /php/ && code = /200/", false},
+		{"(method = /GET/ || method = /POST/) && -(code = /200/ || code = /304/)", true},
+		{"method = /GET/ || method = /PUT/ || method = /HEAD/", false},
+		{"--error", true},
+		{"ipaddr in [192.0.2.1, 10.1.2.3]", true},
+		{"method in [GET, HEAD] || code in [200, 304]", false},
+		{"-(error && method = /POST/) || useragent ~ /curl/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sentence, func(t *testing.T) {
+			if got := compile(t, tt.sentence).Run(testRecord); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile_ThreadsJumps(t *testing.T) {
+	prog := compile(t, "method = /POST/ && url ~ /php/ && code = /403/")
+	want := []Opcode{EXACT, JUMP_FALSE, REGEX, JUMP_FALSE, EXACT}
+	if len(prog.Code) != len(want) {
+		t.Fatalf("Compile() has %d instructions, want %d", len(prog.Code), len(want))
+	}
+	for i := range want {
+		if prog.Code[i].Op != want[i] {
+			t.Errorf("instruction %d is %s, want %s", i, prog.Code[i].Op, want[i])
+		}
+	}
+	// a false method goes straight to the end, not to the second jump
+	if prog.Code[1].Arg != len(prog.Code) {
+		t.Errorf("first JUMP_FALSE goes to %d, want %d", prog.Code[1].Arg, len(prog.Code))
+	}
+
+	prog = compile(t, "(method = /GET/ && url ~ /php/) || code = /403/")
+	// a false method skips the OR's JUMP_TRUE, to check code
+	if prog.Code[1].Op != JUMP_FALSE || prog.Code[1].Arg != 4 {
+		t.Errorf("first jump is %s %d, want JUMP_FALSE 4", prog.Code[1].Op, prog.Code[1].Arg)
+	}
+}
+
+func TestProgram_Dump(t *testing.T) {
+	var buf bytes.Buffer
+	compile(t, "method = /POST/ && -size > /100/").Dump(&buf)
+	want := []string{
+		`   0  EXACT       method = "POST"`,
+		`   1  JUMP_FALSE  4`,
+		`   2  NUMERIC     size > 100`,
+		`   3  NOT`,
+	}
+	if got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Dump() =\n%s\nwant\n%s", buf.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestCompile_NilNode(t *testing.T) {
+	if _, err := Compile(nil); err == nil {
+		t.Errorf("Compile(nil) did not fail")
+	}
+}
+
+func BenchmarkProgram_Run(b *testing.B) {
+	root, err := parser.NewParser(lexer.Lex("(method = /GET/ || method = /POST/) && url ~ /php/ && -success")).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		prog.Run(testRecord)
+	}
+}